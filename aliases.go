@@ -0,0 +1,334 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/robwil/darebee-workout/nodego"
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+const aliasCollection = "aliases"
+
+// The alias marker is a document written alongside the aliases, so that an
+// alias collection emptied by an admin can be told apart from one never seeded.
+const (
+	aliasMarkerCollection = "meta"
+	aliasMarkerDoc        = "aliases"
+)
+
+// defaultAliases seed the alias store the first time it is used.
+var defaultAliases = map[string]string{
+	"alt-arm-leg-raises": "arm-leg-raises",
+	"lunges-exercise":    "forward-lunges",
+}
+
+// aliases maps slugs guessed from OCR text to the slugs Darebee actually uses.
+var aliases = newAliasTable(defaultAliases)
+
+// aliasStore persists the alias table. load returns nil if nothing has been
+// stored yet.
+type aliasStore interface {
+	load(ctx context.Context) (map[string]string, error)
+	set(ctx context.Context, alias string, target string) error
+	remove(ctx context.Context, alias string) error
+}
+
+// aliasTable is the in-memory alias table, which can be reloaded from its store
+// while the service is running.
+type aliasTable struct {
+	store aliasStore
+
+	mu      sync.RWMutex
+	aliases map[string]string
+}
+
+func newAliasTable(initial map[string]string) *aliasTable {
+	t := &aliasTable{aliases: map[string]string{}}
+	for alias, target := range initial {
+		t.aliases[alias] = target
+	}
+	return t
+}
+
+// lookup returns the slug that alias stands for, or "" if it isn't an alias.
+func (t *aliasTable) lookup(alias string) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.aliases[alias]
+}
+
+func (t *aliasTable) all() map[string]string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	all := make(map[string]string, len(t.aliases))
+	for alias, target := range t.aliases {
+		all[alias] = target
+	}
+	return all
+}
+
+// reload replaces the table with the contents of its store, first seeding an
+// empty store with defaultAliases.
+func (t *aliasTable) reload(ctx context.Context) error {
+	loaded, err := t.store.load(ctx)
+	if err != nil {
+		return err
+	}
+	if loaded == nil {
+		for alias, target := range defaultAliases {
+			if err := t.store.set(ctx, alias, target); err != nil {
+				return err
+			}
+		}
+		loaded = defaultAliases
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.aliases = map[string]string{}
+	for alias, target := range loaded {
+		t.aliases[alias] = target
+	}
+	return nil
+}
+
+// reloadEvery keeps the table in sync with its store, so aliases edited
+// directly in the store are picked up without a restart.
+func (t *aliasTable) reloadEvery(ctx context.Context, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := t.reload(ctx); err != nil {
+			log.Printf("Failed reloading aliases: %v", err)
+		}
+	}
+}
+
+func (t *aliasTable) set(ctx context.Context, alias string, target string) error {
+	if err := t.store.set(ctx, alias, target); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.aliases[alias] = target
+	return nil
+}
+
+func (t *aliasTable) remove(ctx context.Context, alias string) error {
+	if err := t.store.remove(ctx, alias); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.aliases, alias)
+	return nil
+}
+
+// fileAliasStore keeps aliases in a JSON object of alias to target slug.
+type fileAliasStore struct {
+	path string
+
+	mu sync.Mutex
+}
+
+func (s *fileAliasStore) load(ctx context.Context) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.read()
+}
+
+func (s *fileAliasStore) read() (map[string]string, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	loaded := map[string]string{}
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return nil, fmt.Errorf("invalid alias file %s: %v", s.path, err)
+	}
+	return loaded, nil
+}
+
+func (s *fileAliasStore) update(change func(map[string]string)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, err := s.read()
+	if err != nil {
+		return err
+	}
+	if stored == nil {
+		stored = map[string]string{}
+	}
+	change(stored)
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path, data, 0644)
+}
+
+func (s *fileAliasStore) set(ctx context.Context, alias string, target string) error {
+	return s.update(func(stored map[string]string) { stored[alias] = target })
+}
+
+func (s *fileAliasStore) remove(ctx context.Context, alias string) error {
+	return s.update(func(stored map[string]string) { delete(stored, alias) })
+}
+
+// firestoreAliasStore keeps aliases in Firestore, one document per alias. Every
+// write also writes the alias marker, so load only returns nil for a store that
+// has never been written to.
+type firestoreAliasStore struct {
+	client *firestore.Client
+}
+
+type aliasDoc struct {
+	Target string `firestore:"target"`
+}
+
+func (s *firestoreAliasStore) load(ctx context.Context) (map[string]string, error) {
+	loaded := map[string]string{}
+	docs := s.client.Collection(aliasCollection).Documents(ctx)
+	defer docs.Stop()
+	for {
+		doc, err := docs.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var alias aliasDoc
+		if err := doc.DataTo(&alias); err != nil {
+			return nil, err
+		}
+		loaded[doc.Ref.ID] = alias.Target
+	}
+	if len(loaded) > 0 {
+		return loaded, nil
+	}
+	marker, err := s.client.Collection(aliasMarkerCollection).Doc(aliasMarkerDoc).Get(ctx)
+	if err != nil && grpc.Code(err) != codes.NotFound {
+		return nil, err
+	}
+	if !marker.Exists() {
+		return nil, nil
+	}
+	return loaded, nil
+}
+
+func (s *firestoreAliasStore) mark(ctx context.Context) error {
+	_, err := s.client.Collection(aliasMarkerCollection).Doc(aliasMarkerDoc).Set(ctx, map[string]interface{}{"updated": time.Now()})
+	return err
+}
+
+func (s *firestoreAliasStore) set(ctx context.Context, alias string, target string) error {
+	if _, err := s.client.Collection(aliasCollection).Doc(alias).Set(ctx, aliasDoc{Target: target}); err != nil {
+		return err
+	}
+	return s.mark(ctx)
+}
+
+func (s *firestoreAliasStore) remove(ctx context.Context, alias string) error {
+	if _, err := s.client.Collection(aliasCollection).Doc(alias).Delete(ctx); err != nil {
+		return err
+	}
+	return s.mark(ctx)
+}
+
+// validateAliasTarget checks that target is a real exercise with a video, so a
+// typo in an alias can't hide a working guess.
+func validateAliasTarget(ctx context.Context, catalog *exerciseCatalog, target string) error {
+	if entry, ok := catalog.entry(target); ok && entry.media() != nil {
+		return nil
+	}
+	_, err := lookupExercisePage(ctx, getVideoURL(target))
+	return err
+}
+
+// adminAliases lists (GET), adds (POST alias=...&target=...) and removes
+// (DELETE ?alias=...) aliases. POST /reload reloads them from the store.
+// Aliases may be given as exercise names, as they are stored in the slug form
+// they are looked up by. Requests must carry the admin token as a bearer token.
+func adminAliases(table *aliasTable, catalog *exerciseCatalog, token string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("%s %s", r.Method, r.RequestURI)
+		if token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if r.URL.Path == nodego.HTTPTrigger+"/admin/aliases/reload" {
+			if r.Method != "POST" {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if err := table.reload(r.Context()); err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			writeAliases(w, table)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			writeAliases(w, table)
+		case "POST":
+			alias, target := aliasKey(r.FormValue("alias")), r.FormValue("target")
+			if alias == "" || target == "" {
+				http.Error(w, "alias and target are required", http.StatusBadRequest)
+				return
+			}
+			if err := validateAliasTarget(r.Context(), catalog, target); err != nil {
+				http.Error(w, fmt.Sprintf("invalid target %s: %v", target, err), http.StatusBadRequest)
+				return
+			}
+			if err := table.set(r.Context(), alias, target); err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			writeAliases(w, table)
+		case "DELETE":
+			alias := aliasKey(r.URL.Query().Get("alias"))
+			if alias == "" {
+				http.Error(w, "alias is required", http.StatusBadRequest)
+				return
+			}
+			if err := table.remove(r.Context(), alias); err != nil {
+				http.Error(w, err.Error(), 500)
+				return
+			}
+			writeAliases(w, table)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// aliasKey returns the slug form of an alias, or "" if it has no letters or
+// digits to make one from.
+func aliasKey(alias string) string {
+	if strings.Trim(alias, " -_/") == "" {
+		return ""
+	}
+	return exerciseSlug(strings.TrimSpace(alias))
+}
+
+func writeAliases(w http.ResponseWriter, table *aliasTable) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(table.all()); err != nil {
+		log.Printf("Failed writing aliases: %v", err)
+	}
+}
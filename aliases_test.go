@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/robwil/darebee-workout/nodego"
+	"golang.org/x/net/context"
+	"gotest.tools/assert"
+)
+
+func newTestAliasTable(t *testing.T) (*aliasTable, func()) {
+	dir, err := ioutil.TempDir("", "aliases")
+	assert.NilError(t, err)
+	table := newAliasTable(nil)
+	table.store = &fileAliasStore{path: filepath.Join(dir, "aliases.json")}
+	return table, func() { os.RemoveAll(dir) }
+}
+
+func TestAliasTable(t *testing.T) {
+	table, cleanup := newTestAliasTable(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	t.Run("empty store is seeded with defaults", func(t *testing.T) {
+		assert.NilError(t, table.reload(ctx))
+		assert.Equal(t, "forward-lunges", table.lookup("lunges-exercise"))
+		stored, err := table.store.load(ctx)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, defaultAliases, stored)
+	})
+	t.Run("changes are persisted", func(t *testing.T) {
+		assert.NilError(t, table.set(ctx, "squat-exercise", "squats-exercise"))
+		assert.NilError(t, table.remove(ctx, "lunges-exercise"))
+		assert.Equal(t, "", table.lookup("lunges-exercise"))
+		stored, err := table.store.load(ctx)
+		assert.NilError(t, err)
+		assert.Equal(t, "squats-exercise", stored["squat-exercise"])
+		_, ok := stored["lunges-exercise"]
+		assert.Assert(t, !ok)
+	})
+	t.Run("reload picks up edits made to the store", func(t *testing.T) {
+		assert.NilError(t, table.store.set(ctx, "jumpinq-jacks", "jumping-jacks"))
+		assert.Equal(t, "", table.lookup("jumpinq-jacks"))
+		assert.NilError(t, table.reload(ctx))
+		assert.Equal(t, "jumping-jacks", table.lookup("jumpinq-jacks"))
+	})
+	t.Run("removing every alias doesn't seed defaults again", func(t *testing.T) {
+		for alias := range table.all() {
+			assert.NilError(t, table.remove(ctx, alias))
+		}
+		assert.NilError(t, table.reload(ctx))
+		assert.DeepEqual(t, map[string]string{}, table.all())
+	})
+}
+
+func TestAdminAliases(t *testing.T) {
+	table, cleanup := newTestAliasTable(t)
+	defer cleanup()
+	catalog := newExerciseCatalog([]catalogEntry{{Slug: "jumping-jacks", EmbedID: "jacksID"}})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/exercises/no-video.html" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `<p>Coming soon</p>`)
+	}))
+	defer server.Close()
+	defer useUpstream(server.URL)()
+	defer useOutbound(newUpstreamTransport(server.Client().Transport, upstreamOptions{}))()
+	request := func(method string, path string, form url.Values, token string) *httptest.ResponseRecorder {
+		return adminRequest(adminAliases(table, catalog, "secret"), method, path, form, token)
+	}
+
+	t.Run("requires admin token", func(t *testing.T) {
+		assert.Equal(t, http.StatusForbidden, request("GET", "/admin/aliases", nil, "").Code)
+		assert.Equal(t, http.StatusForbidden, request("GET", "/admin/aliases", nil, "wrong").Code)
+	})
+	t.Run("disabled without a configured token", func(t *testing.T) {
+		w := adminRequest(adminAliases(table, catalog, ""), "GET", "/admin/aliases", nil, "")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+	t.Run("add alias", func(t *testing.T) {
+		w := request("POST", "/admin/aliases", url.Values{"alias": {"jumpinq-jacks"}, "target": {"jumping-jacks"}}, "secret")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "jumping-jacks", table.lookup("jumpinq-jacks"))
+	})
+	t.Run("alias names are stored as slugs", func(t *testing.T) {
+		w := request("POST", "/admin/aliases", url.Values{"alias": {"Alt Jumping / Jacks"}, "target": {"jumping-jacks"}}, "secret")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "jumping-jacks", table.lookup("alt-jumping-jacks"))
+		restore := useAliases(table)
+		assert.Equal(t, "jumping-jacks", getVideoName("10 alt jumping / jacks"))
+		restore()
+		w = request("DELETE", "/admin/aliases?alias=Alt+Jumping+Jacks", nil, "secret")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "", table.lookup("alt-jumping-jacks"))
+	})
+	t.Run("targets without a video are rejected", func(t *testing.T) {
+		for _, target := range []string{"no-such-exercise", "no-video"} {
+			w := request("POST", "/admin/aliases", url.Values{"alias": {"mystery"}, "target": {target}}, "secret")
+			assert.Equal(t, http.StatusBadRequest, w.Code, target)
+			assert.Equal(t, "", table.lookup("mystery-exercise"))
+		}
+	})
+	t.Run("add alias without target", func(t *testing.T) {
+		w := request("POST", "/admin/aliases", url.Values{"alias": {"jumpinq-jacks"}}, "secret")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+	t.Run("remove alias", func(t *testing.T) {
+		w := request("DELETE", "/admin/aliases?alias=jumpinq-jacks", nil, "secret")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "", table.lookup("jumpinq-jacks"))
+	})
+	t.Run("reload", func(t *testing.T) {
+		assert.Equal(t, http.StatusMethodNotAllowed, request("GET", "/admin/aliases/reload", nil, "secret").Code)
+		assert.NilError(t, table.store.set(context.Background(), "squat-exercise", "squats-exercise"))
+		w := request("POST", "/admin/aliases/reload", nil, "secret")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Assert(t, strings.Contains(w.Body.String(), `"squat-exercise":"squats-exercise"`))
+	})
+}
+
+func adminRequest(handler http.HandlerFunc, method string, path string, form url.Values, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, nodego.HTTPTrigger+path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"strings"
+)
+
+// seedExercises are exercise slugs known to exist in the Darebee exercise
+// library, used until the library has been crawled.
+var seedExercises = []string{
+	"arm-leg-raises",
+	"burpees-exercise",
+	"burpees-with-push-up",
+	"bridges-exercise",
+	"butt-kicks",
+	"calf-raises",
+	"climbers-exercise",
+	"crunches-exercise",
+	"flutter-kicks",
+	"forward-lunges",
+	"high-knees",
+	"jab-jab-cross",
+	"jumping-jacks",
+	"knee-strikes",
+	"leg-raises",
+	"low-front-kicks",
+	"overhead-punches",
+	"plank-exercise",
+	"push-ups-exercise",
+	"punches-exercise",
+	"side-leg-raises",
+	"side-to-side-chops",
+	"sit-ups-exercise",
+	"skiers-exercise",
+	"squats-exercise",
+}
+
+func seedCatalogEntries() []catalogEntry {
+	var entries []catalogEntry
+	for _, slug := range seedExercises {
+		entries = append(entries, catalogEntry{Slug: slug})
+	}
+	return entries
+}
+
+// exerciseCatalog is the set of exercises known to exist, used to correct OCR
+// mistakes in exercise names and, once crawled, to look up their videos
+// without scraping each exercise page.
+type exerciseCatalog struct {
+	slugs   []string
+	entries map[string]catalogEntry
+}
+
+func newExerciseCatalog(entries []catalogEntry) *exerciseCatalog {
+	c := &exerciseCatalog{entries: map[string]catalogEntry{}}
+	for _, entry := range entries {
+		if _, ok := c.entries[entry.Slug]; !ok {
+			c.slugs = append(c.slugs, entry.Slug)
+		}
+		c.entries[entry.Slug] = entry
+	}
+	return c
+}
+
+// entry returns what the catalog knows about slug.
+func (c *exerciseCatalog) entry(slug string) (catalogEntry, bool) {
+	entry, ok := c.entries[slug]
+	return entry, ok
+}
+
+// catalogMatch is the catalog slug closest to a guessed slug. Score is 1 for an
+// exact match and approaches 0 as the names diverge.
+type catalogMatch struct {
+	Slug  string
+	Score float64
+}
+
+// match finds the known slug most similar to the guessed one. ok is false if
+// nothing scores at least threshold, in which case the guess should be used as is.
+func (c *exerciseCatalog) match(guess string, threshold float64) (match catalogMatch, ok bool) {
+	if _, ok := c.entries[guess]; ok {
+		return catalogMatch{Slug: guess, Score: 1}, true
+	}
+	for _, slug := range c.slugs {
+		if score := slugSimilarity(guess, slug); score > match.Score {
+			match = catalogMatch{Slug: slug, Score: score}
+		}
+	}
+	return match, match.Score >= threshold
+}
+
+// slugSimilarity scores how alike two slugs are, as the better of their overall
+// edit distance and a word by word comparison, which tolerates words being
+// reordered or dropped by OCR.
+func slugSimilarity(a string, b string) float64 {
+	a = strings.TrimSuffix(a, "-exercise")
+	b = strings.TrimSuffix(b, "-exercise")
+	editScore := editSimilarity(a, b)
+	tokenScore := tokenSimilarity(strings.Split(a, "-"), strings.Split(b, "-"))
+	if tokenScore > editScore {
+		return tokenScore
+	}
+	return editScore
+}
+
+// editSimilarity is 1 minus the edit distance between a and b, relative to the
+// longer of the two.
+func editSimilarity(a string, b string) float64 {
+	longest := len(a)
+	if len(b) > longest {
+		longest = len(b)
+	}
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(levenshtein(a, b))/float64(longest)
+}
+
+// tokenSimilarity is how well each word matches its most similar word on the
+// other side, taking the worse of the two directions so that a name isn't
+// matched to one that only contains some of its words, like "wide push-ups"
+// to "push-ups".
+func tokenSimilarity(a []string, b []string) float64 {
+	ab, ba := bestTokenMatches(a, b), bestTokenMatches(b, a)
+	if ba < ab {
+		return ba
+	}
+	return ab
+}
+
+func bestTokenMatches(from []string, to []string) float64 {
+	if len(from) == 0 {
+		return 0
+	}
+	var total float64
+	for _, f := range from {
+		var best float64
+		for _, t := range to {
+			if score := editSimilarity(f, t); score > best {
+				best = score
+			}
+		}
+		total += best
+	}
+	return total / float64(len(from))
+}
+
+func levenshtein(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
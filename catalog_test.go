@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestExerciseCatalogMatch(t *testing.T) {
+	catalog := newExerciseCatalog(seedCatalogEntries())
+	t.Run("exact match", func(t *testing.T) {
+		match, ok := catalog.match("knee-strikes", 0.8)
+		assert.Assert(t, ok)
+		assert.DeepEqual(t, catalogMatch{Slug: "knee-strikes", Score: 1}, match)
+	})
+	t.Run("OCR misread", func(t *testing.T) {
+		match, ok := catalog.match(getVideoName("20 jumpinq jacks"), 0.8)
+		assert.Assert(t, ok)
+		assert.Equal(t, "jumping-jacks", match.Slug)
+		assert.Assert(t, match.Score < 1)
+	})
+	t.Run("single word exercise", func(t *testing.T) {
+		match, ok := catalog.match(getVideoName("10 bridqes"), 0.8)
+		assert.Assert(t, ok)
+		assert.Equal(t, "bridges-exercise", match.Slug)
+	})
+	t.Run("reordered words", func(t *testing.T) {
+		match, ok := catalog.match("leg-side-raises", 0.8)
+		assert.Assert(t, ok)
+		assert.Equal(t, "side-leg-raises", match.Slug)
+	})
+	t.Run("nothing close enough", func(t *testing.T) {
+		_, ok := catalog.match("handstand-walk", 0.8)
+		assert.Assert(t, !ok)
+	})
+	t.Run("more specific exercises", func(t *testing.T) {
+		_, ok := catalog.match("wide-push-ups", 0.8)
+		assert.Assert(t, !ok)
+		_, ok = catalog.match("jump-squats", 0.8)
+		assert.Assert(t, !ok)
+	})
+}
+
+func TestSlugSimilarity(t *testing.T) {
+	assert.Equal(t, 1.0, slugSimilarity("bridges-exercise", "bridges-exercise"))
+	assert.Assert(t, slugSimilarity("jumpinq-jacks", "jumping-jacks") > slugSimilarity("jumpinq-jacks", "jab-jab-cross"))
+	assert.Equal(t, 3, levenshtein("kitten", "sitting"))
+}
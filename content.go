@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// Kinds of Darebee content that come with a workout graphic.
+const (
+	contentProgram   = "program"
+	contentWorkout   = "workout"
+	contentChallenge = "challenge"
+)
+
+// contentRequest identifies a single workout graphic: a day of a program or
+// challenge, or a standalone workout (which has no days).
+type contentRequest struct {
+	Type string
+	Name string
+	Day  string
+}
+
+// contentType describes how to find the graphic for one kind of content.
+type contentType struct {
+	hasDays bool
+	// getImageURLs returns the URLs the graphic may be at, most likely first.
+	getImageURLs func(name string, day string) ([]string, error)
+}
+
+var contentTypes = map[string]contentType{
+	contentProgram:   {hasDays: true, getImageURLs: getProgramImageURLs},
+	contentChallenge: {hasDays: true, getImageURLs: getChallengeImageURLs},
+	contentWorkout:   {hasDays: false, getImageURLs: getWorkoutImageURLs},
+}
+
+// dayImageVariants are the file names day graphics have been published under.
+// Most programs use the first, but older ones use other folders, extensions or
+// unpadded day numbers.
+var dayImageVariants = []string{
+	"web/day%02d.jpg",
+	"web/day%d.jpg",
+	"web/day%02d.png",
+	"web/day%d.png",
+	"day%02d.jpg",
+	"day%d.jpg",
+}
+
+// workoutImageVariants are the file names standalone workout graphics have been
+// published under.
+var workoutImageVariants = []string{"%s.jpg", "%s.png"}
+
+// parseContentRequest reads the content to show from the query params: type
+// (defaulting to program), workout and, for programs and challenges, day.
+func parseContentRequest(q url.Values) (contentRequest, error) {
+	req := contentRequest{Type: contentProgram}
+	if _, ok := q["type"]; ok {
+		value, err := parseQueryParam(q, "type")
+		if err != nil {
+			return req, err
+		}
+		req.Type = strings.ToLower(value)
+	}
+	kind, ok := contentTypes[req.Type]
+	if !ok {
+		return req, fmt.Errorf("unknown content type %q", req.Type)
+	}
+	var err error
+	if req.Name, err = parseQueryParam(q, "workout"); err != nil {
+		return req, err
+	}
+	if kind.hasDays {
+		if req.Day, err = parseQueryParam(q, "day"); err != nil {
+			return req, err
+		}
+	}
+	return req, nil
+}
+
+// imageURLs returns the URLs the graphic for req may be at, most likely first.
+func (req contentRequest) imageURLs() ([]string, error) {
+	kind, ok := contentTypes[req.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown content type %q", req.Type)
+	}
+	return kind.getImageURLs(req.Name, req.Day)
+}
+
+// imageURL returns the usual URL of the graphic for req.
+func (req contentRequest) imageURL() (string, error) {
+	imageURLs, err := req.imageURLs()
+	if err != nil {
+		return "", err
+	}
+	return imageURLs[0], nil
+}
+
+func getProgramImageURLs(program string, day string) ([]string, error) {
+	return getDayImageURLs("programs", program, day)
+}
+
+func getChallengeImageURLs(challenge string, day string) ([]string, error) {
+	return getDayImageURLs("challenges", challenge, day)
+}
+
+func getDayImageURLs(folder string, name string, day string) ([]string, error) {
+	dayNum, err := strconv.Atoi(day)
+	if err != nil {
+		return nil, err
+	}
+	var imageURLs []string
+	for _, variant := range dayImageVariants {
+		imageURLs = append(imageURLs, upstream(fmt.Sprintf("/images/%s/%s/", folder, strings.ToLower(name))+fmt.Sprintf(variant, dayNum)))
+	}
+	return imageURLs, nil
+}
+
+// getWorkoutImageURLs returns the graphic of a standalone workout, named by the
+// slug of its page, e.g. "fighter-workout". Workouts have no days.
+func getWorkoutImageURLs(workout string, day string) ([]string, error) {
+	var imageURLs []string
+	for _, variant := range workoutImageVariants {
+		imageURLs = append(imageURLs, upstream("/images/workouts/"+fmt.Sprintf(variant, strings.ToLower(workout))))
+	}
+	return imageURLs, nil
+}
+
+// imageResolver finds which of the candidate URLs a graphic is actually at,
+// probing them with HEAD requests. The variant that worked is remembered per
+// program (or challenge, or workout) and tried first next time, as a program
+// publishes all its days the same way.
+type imageResolver struct {
+	client *http.Client
+
+	mu       sync.Mutex
+	variants map[string]int
+}
+
+func newImageResolver(client *http.Client) *imageResolver {
+	return &imageResolver{client: client, variants: map[string]int{}}
+}
+
+// resolve returns the URL of the graphic for req, or an imageFetchError with
+// a 404 status if none of the candidates exist.
+func (r *imageResolver) resolve(ctx context.Context, req contentRequest) (string, error) {
+	imageURLs, err := req.imageURLs()
+	if err != nil {
+		return "", err
+	}
+	key := req.Type + "/" + strings.ToLower(req.Name)
+	r.mu.Lock()
+	remembered, ok := r.variants[key]
+	r.mu.Unlock()
+	order := make([]int, 0, len(imageURLs))
+	if ok {
+		order = append(order, remembered)
+	}
+	for i := range imageURLs {
+		if !ok || i != remembered {
+			order = append(order, i)
+		}
+	}
+	for _, i := range order {
+		found, err := r.exists(ctx, imageURLs[i])
+		if err != nil {
+			return "", err
+		}
+		if found {
+			r.mu.Lock()
+			r.variants[key] = i
+			r.mu.Unlock()
+			return imageURLs[i], nil
+		}
+	}
+	return "", &imageFetchError{
+		URL:        imageURLs[0],
+		StatusCode: http.StatusNotFound,
+		Err:        fmt.Errorf("image not found at any of %d candidate URLs", len(imageURLs)),
+	}
+}
+
+func (r *imageResolver) exists(ctx context.Context, imageURL string) (bool, error) {
+	req, err := http.NewRequest("HEAD", imageURL, nil)
+	if err != nil {
+		return false, &imageFetchError{URL: imageURL, Err: err}
+	}
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, &imageFetchError{URL: imageURL, Err: err}
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html"), nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden:
+		return false, nil
+	}
+	return false, &imageFetchError{URL: imageURL, StatusCode: resp.StatusCode}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"golang.org/x/net/context"
+	"gotest.tools/assert"
+)
+
+func TestParseContentRequest(t *testing.T) {
+	t.Run("program by default", func(t *testing.T) {
+		req, err := parseContentRequest(url.Values{"workout": {"foundation"}, "day": {"3"}})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, contentRequest{Type: "program", Name: "foundation", Day: "3"}, req)
+	})
+	t.Run("challenge", func(t *testing.T) {
+		req, err := parseContentRequest(url.Values{"type": {"Challenge"}, "workout": {"abs-of-steel"}, "day": {"12"}})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, contentRequest{Type: "challenge", Name: "abs-of-steel", Day: "12"}, req)
+	})
+	t.Run("standalone workouts have no day", func(t *testing.T) {
+		req, err := parseContentRequest(url.Values{"type": {"workout"}, "workout": {"fighter-workout"}})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, contentRequest{Type: "workout", Name: "fighter-workout"}, req)
+	})
+	t.Run("missing day", func(t *testing.T) {
+		_, err := parseContentRequest(url.Values{"type": {"challenge"}, "workout": {"abs-of-steel"}})
+		assert.Error(t, err, "param day not found")
+	})
+	t.Run("unknown type", func(t *testing.T) {
+		_, err := parseContentRequest(url.Values{"type": {"podcast"}, "workout": {"foundation"}})
+		assert.Error(t, err, `unknown content type "podcast"`)
+	})
+}
+
+func TestContentRequestImageURL(t *testing.T) {
+	t.Run("program day", func(t *testing.T) {
+		imageURL, err := contentRequest{Type: "program", Name: "foundation", Day: "3"}.imageURL()
+		assert.NilError(t, err)
+		assert.Equal(t, "https://darebee.com/images/programs/foundation/web/day03.jpg", imageURL)
+	})
+	t.Run("challenge day", func(t *testing.T) {
+		imageURL, err := contentRequest{Type: "challenge", Name: "Abs-Of-Steel", Day: "12"}.imageURL()
+		assert.NilError(t, err)
+		assert.Equal(t, "https://darebee.com/images/challenges/abs-of-steel/web/day12.jpg", imageURL)
+	})
+	t.Run("standalone workout", func(t *testing.T) {
+		imageURL, err := contentRequest{Type: "workout", Name: "fighter-workout"}.imageURL()
+		assert.NilError(t, err)
+		assert.Equal(t, "https://darebee.com/images/workouts/fighter-workout.jpg", imageURL)
+	})
+	t.Run("invalid day", func(t *testing.T) {
+		_, err := contentRequest{Type: "challenge", Name: "abs-of-steel", Day: "twelve"}.imageURL()
+		assert.Assert(t, err != nil)
+	})
+}
+
+func TestImageResolver(t *testing.T) {
+	var probes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes = append(probes, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/images/programs/foundation/web/day03.jpg", "/images/programs/ironborn/day7.jpg", "/images/programs/ironborn/day8.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+		case "/images/programs/soft404/web/day01.jpg":
+			w.Header().Set("Content-Type", "text/html")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	defer useUpstream(server.URL)()
+	resolver := newImageResolver(server.Client())
+	resolve := func(name string, day string) (string, error) {
+		probes = nil
+		return resolver.resolve(context.Background(), contentRequest{Type: contentProgram, Name: name, Day: day})
+	}
+
+	t.Run("usual URL", func(t *testing.T) {
+		imageURL, err := resolve("foundation", "3")
+		assert.NilError(t, err)
+		assert.Equal(t, server.URL+"/images/programs/foundation/web/day03.jpg", imageURL)
+		assert.DeepEqual(t, []string{"HEAD /images/programs/foundation/web/day03.jpg"}, probes)
+	})
+	t.Run("falls back to other variants", func(t *testing.T) {
+		imageURL, err := resolve("ironborn", "7")
+		assert.NilError(t, err)
+		assert.Equal(t, server.URL+"/images/programs/ironborn/day7.jpg", imageURL)
+		assert.Equal(t, len(dayImageVariants), len(probes))
+	})
+	t.Run("remembers the variant per program", func(t *testing.T) {
+		imageURL, err := resolve("ironborn", "8")
+		assert.NilError(t, err)
+		assert.Equal(t, server.URL+"/images/programs/ironborn/day8.jpg", imageURL)
+		assert.DeepEqual(t, []string{"HEAD /images/programs/ironborn/day8.jpg"}, probes)
+	})
+	t.Run("image not found", func(t *testing.T) {
+		_, err := resolve("soft404", "1")
+		fetchErr, ok := err.(*imageFetchError)
+		assert.Assert(t, ok)
+		assert.Equal(t, http.StatusNotFound, fetchErr.StatusCode)
+		assert.Error(t, err, "failed to fetch image "+server.URL+"/images/programs/soft404/web/day01.jpg: image not found at any of 6 candidate URLs")
+	})
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"golang.org/x/net/context"
+	"google.golang.org/api/iterator"
+)
+
+const catalogCollection = "catalog"
+
+// catalogEntry is an exercise in the Darebee exercise library.
+type catalogEntry struct {
+	Slug    string           `json:"slug" firestore:"slug"`
+	Name    string           `json:"name" firestore:"name"`
+	PageURL string           `json:"pageURL" firestore:"pageURL"`
+	EmbedID string           `json:"embedID" firestore:"embedID"`
+	Media   *exerciseMedia   `json:"media,omitempty" firestore:"media,omitempty"`
+	Details *exerciseDetails `json:"details,omitempty" firestore:"details,omitempty"`
+	// Error is why the exercise page couldn't be crawled, in which case the
+	// page is scraped when the exercise is looked up instead.
+	Error string `json:"error,omitempty" firestore:"error,omitempty"`
+}
+
+// media returns the exercise's video, including for catalogs crawled before
+// anything but YouTube was recognised.
+func (e catalogEntry) media() *exerciseMedia {
+	if e.Media != nil {
+		return e.Media
+	}
+	return youtubeMedia(e.EmbedID)
+}
+
+var (
+	exerciseLinkRegexp = regexp.MustCompile(`<a[^>]+href="([^"]*/exercises/([a-z0-9-]+)\.html)"[^>]*>((?s:.*?))</a>`)
+	pageLinkRegexp     = regexp.MustCompile(`<a[^>]+href="([^"]*[?&]start=\d+[^"]*)"`)
+	tagRegexp          = regexp.MustCompile(`<[^>]*>`)
+)
+
+// libraryCrawler walks the paginated exercise library index and visits every
+// exercise page it links to.
+type libraryCrawler struct {
+	client   *http.Client
+	maxPages int
+}
+
+// crawl returns an entry for every exercise reachable from indexURL, following
+// the index's pagination links. Exercise pages that fail to download are
+// recorded on their entries rather than failing the crawl; only the index
+// pages themselves are required.
+func (c *libraryCrawler) crawl(ctx context.Context, indexURL string) ([]catalogEntry, error) {
+	var entries []catalogEntry
+	seenExercises := map[string]int{}
+	seenPages := map[string]bool{indexURL: true}
+	queue := []string{indexURL}
+	crawled := 0
+	for len(queue) > 0 && crawled < c.maxPages {
+		pageURL := queue[0]
+		queue = queue[1:]
+		log.Printf("Crawling index page %s", pageURL)
+		body, err := c.get(ctx, pageURL)
+		if err != nil {
+			return nil, err
+		}
+		crawled++
+		for _, link := range exerciseLinkRegexp.FindAllStringSubmatch(body, -1) {
+			slug := link[2]
+			// exercises are often linked twice, from their image and their name
+			name := strings.TrimSpace(html.UnescapeString(tagRegexp.ReplaceAllString(link[3], "")))
+			if i, ok := seenExercises[slug]; ok {
+				if entries[i].Name == "" {
+					entries[i].Name = name
+				}
+				continue
+			}
+			seenExercises[slug] = len(entries)
+			exerciseURL, err := resolveLink(pageURL, link[1])
+			if err != nil {
+				return nil, err
+			}
+			entry := catalogEntry{Slug: slug, Name: name, PageURL: exerciseURL}
+			page, err := scrapeExercisePage(ctx, c.client, exerciseURL)
+			switch err.(type) {
+			case nil:
+				entry.EmbedID, entry.Media, entry.Details = page.EmbedID, page.Media, page.details()
+			case *pageNotFoundError:
+				// linked from the index, but gone; keep it for its name
+			default:
+				log.Printf("Failed crawling exercise %s: %v", slug, err)
+				entry.Error = err.Error()
+			}
+			entries = append(entries, entry)
+		}
+		for _, link := range pageLinkRegexp.FindAllStringSubmatch(body, -1) {
+			nextURL, err := resolveLink(pageURL, html.UnescapeString(link[1]))
+			if err != nil {
+				return nil, err
+			}
+			if !seenPages[nextURL] {
+				seenPages[nextURL] = true
+				queue = append(queue, nextURL)
+			}
+		}
+	}
+	return entries, nil
+}
+
+func (c *libraryCrawler) get(ctx context.Context, pageURL string) (string, error) {
+	return fetchPage(ctx, c.client, pageURL)
+}
+
+// fetchPage downloads a Darebee page as text, returning a pageNotFoundError if
+// it doesn't exist and an upstreamUnavailableError if it couldn't be fetched.
+func fetchPage(ctx context.Context, client *http.Client, pageURL string) (string, error) {
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", &upstreamUnavailableError{URL: pageURL, Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", &pageNotFoundError{URL: pageURL}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", &upstreamUnavailableError{URL: pageURL, StatusCode: resp.StatusCode}
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", &upstreamUnavailableError{URL: pageURL, Err: err}
+	}
+	return string(body), nil
+}
+
+func resolveLink(pageURL string, href string) (string, error) {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return "", err
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// catalogStore persists the crawled exercise catalog.
+type catalogStore interface {
+	load(ctx context.Context) ([]catalogEntry, error)
+	save(ctx context.Context, entries []catalogEntry) error
+}
+
+// fileCatalogStore keeps the catalog in a JSON file.
+type fileCatalogStore struct {
+	path string
+}
+
+func (s *fileCatalogStore) load(ctx context.Context) ([]catalogEntry, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []catalogEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid catalog file %s: %v", s.path, err)
+	}
+	return entries, nil
+}
+
+func (s *fileCatalogStore) save(ctx context.Context, entries []catalogEntry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path, data, 0644)
+}
+
+// firestoreCatalogStore keeps the catalog in Firestore, one document per slug.
+type firestoreCatalogStore struct {
+	client *firestore.Client
+}
+
+func (s *firestoreCatalogStore) load(ctx context.Context) ([]catalogEntry, error) {
+	var entries []catalogEntry
+	docs := s.client.Collection(catalogCollection).Documents(ctx)
+	defer docs.Stop()
+	for {
+		doc, err := docs.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var entry catalogEntry
+		if err := doc.DataTo(&entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (s *firestoreCatalogStore) save(ctx context.Context, entries []catalogEntry) error {
+	for _, entry := range entries {
+		if _, err := s.client.Collection(catalogCollection).Doc(entry.Slug).Set(ctx, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadCatalog reads the crawled catalog, falling back to the seed list of
+// exercises if nothing has been crawled yet.
+func loadCatalog(ctx context.Context, store catalogStore) *exerciseCatalog {
+	entries, err := store.load(ctx)
+	if err != nil {
+		log.Printf("Failed loading exercise catalog, using seed list: %v", err)
+	}
+	if len(entries) == 0 {
+		return newExerciseCatalog(seedCatalogEntries())
+	}
+	log.Printf("Loaded %d exercises into the catalog", len(entries))
+	return newExerciseCatalog(entries)
+}
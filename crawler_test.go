@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/net/context"
+	"gotest.tools/assert"
+)
+
+// newLibraryServer serves a two page exercise library index and its exercise pages.
+func newLibraryServer() *httptest.Server {
+	pages := map[string]string{
+		"/exercises.html": `
+			<div class="items">
+				<a href="/exercises/knee-strikes.html"><img src="/images/knee-strikes.jpg"/></a>
+				<a href="/exercises/knee-strikes.html">Knee Strikes</a>
+				<a href="/exercises/jumping-jacks.html"><span>Jumping Jacks</span></a>
+			</div>
+			<ul class="pagination"><li><a href="/exercises.html?start=20">2</a></li></ul>`,
+		"/exercises.html?start=20": `
+			<a class="item" href="/exercises/side-to-side-chops.html">Side-to-Side Chops</a>
+			<ul class="pagination"><li><a href="/exercises.html?start=0">1</a></li></ul>`,
+		"/exercises.html?start=0":            `<a href="/exercises/knee-strikes.html">Knee Strikes</a>`,
+		"/exercises/knee-strikes.html":       `<iframe src="https://www.youtube.com/embed/kneeID?rel=0"></iframe>`,
+		"/exercises/jumping-jacks.html":      `<iframe src="https://www.youtube.com/embed/jacksID?rel=0"></iframe>`,
+		"/exercises/side-to-side-chops.html": `<p>No video yet</p>`,
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.RequestURI()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, page)
+	}))
+}
+
+func TestLibraryCrawler(t *testing.T) {
+	server := newLibraryServer()
+	defer server.Close()
+	crawler := &libraryCrawler{client: server.Client(), maxPages: 10}
+
+	entries, err := crawler.crawl(context.Background(), server.URL+"/exercises.html")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []catalogEntry{
+		{Slug: "knee-strikes", Name: "Knee Strikes", PageURL: server.URL + "/exercises/knee-strikes.html", EmbedID: "kneeID", Media: youtubeMedia("kneeID")},
+		{Slug: "jumping-jacks", Name: "Jumping Jacks", PageURL: server.URL + "/exercises/jumping-jacks.html", EmbedID: "jacksID", Media: youtubeMedia("jacksID")},
+		{Slug: "side-to-side-chops", Name: "Side-to-Side Chops", PageURL: server.URL + "/exercises/side-to-side-chops.html", EmbedID: "", Details: &exerciseDetails{Description: "No video yet"}},
+	}, entries)
+}
+
+// countingTransport counts the requests sent through it.
+type countingTransport struct {
+	requests int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestLibraryCrawlerExerciseFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/exercises.html":
+			fmt.Fprint(w, `<a href="/exercises/broken.html">Broken</a><a href="/exercises/knee-strikes.html">Knee Strikes</a>`)
+		case "/exercises/knee-strikes.html":
+			fmt.Fprint(w, `<iframe src="https://www.youtube.com/embed/kneeID?rel=0"></iframe>`)
+		default:
+			http.Error(w, "oops", http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	transport := &countingTransport{}
+	crawler := &libraryCrawler{client: &http.Client{Transport: transport}, maxPages: 10}
+
+	entries, err := crawler.crawl(context.Background(), server.URL+"/exercises.html")
+	assert.NilError(t, err)
+	assert.Equal(t, 3, transport.requests)
+	assert.DeepEqual(t, []catalogEntry{
+		{Slug: "broken", Name: "Broken", PageURL: server.URL + "/exercises/broken.html", Error: "GET " + server.URL + "/exercises/broken.html: upstream returned 500"},
+		{Slug: "knee-strikes", Name: "Knee Strikes", PageURL: server.URL + "/exercises/knee-strikes.html", EmbedID: "kneeID", Media: youtubeMedia("kneeID")},
+	}, entries)
+}
+
+func TestLibraryCrawlerMaxPages(t *testing.T) {
+	var indexPages []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/exercises.html" {
+			http.NotFound(w, r)
+			return
+		}
+		indexPages = append(indexPages, r.URL.RequestURI())
+		start := r.URL.Query().Get("start")
+		fmt.Fprintf(w, `<a href="/exercises/exercise-%s.html">Exercise %s</a>`, start, start)
+		if start == "" {
+			for i := 1; i <= 5; i++ {
+				fmt.Fprintf(w, `<a href="/exercises.html?start=%d">%d</a>`, i*20, i+1)
+			}
+		}
+	}))
+	defer server.Close()
+	crawler := &libraryCrawler{client: server.Client(), maxPages: 3}
+
+	entries, err := crawler.crawl(context.Background(), server.URL+"/exercises.html")
+	assert.NilError(t, err)
+	assert.DeepEqual(t, []string{"/exercises.html", "/exercises.html?start=20", "/exercises.html?start=40"}, indexPages)
+	assert.Equal(t, 3, len(entries))
+}
+
+func TestLibraryCrawlerMissingIndex(t *testing.T) {
+	server := newLibraryServer()
+	defer server.Close()
+	crawler := &libraryCrawler{client: server.Client(), maxPages: 10}
+
+	_, err := crawler.crawl(context.Background(), server.URL+"/library.html")
+	_, ok := err.(*pageNotFoundError)
+	assert.Assert(t, ok, "got %v", err)
+}
+
+func TestFileCatalogStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalog")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	store := &fileCatalogStore{path: filepath.Join(dir, "catalog.json")}
+
+	t.Run("nothing crawled yet", func(t *testing.T) {
+		entries, err := store.load(context.Background())
+		assert.NilError(t, err)
+		assert.Equal(t, 0, len(entries))
+		assert.Assert(t, loadCatalog(context.Background(), store) != nil)
+	})
+	t.Run("round trip", func(t *testing.T) {
+		saved := []catalogEntry{{Slug: "knee-strikes", Name: "Knee Strikes", PageURL: "https://darebee.com/exercises/knee-strikes.html", EmbedID: "kneeID", Media: youtubeMedia("kneeID")}}
+		assert.NilError(t, store.save(context.Background(), saved))
+		entries, err := store.load(context.Background())
+		assert.NilError(t, err)
+		assert.DeepEqual(t, saved, entries)
+		entry, ok := loadCatalog(context.Background(), store).entry("knee-strikes")
+		assert.Assert(t, ok)
+		assert.Equal(t, "kneeID", entry.EmbedID)
+	})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/vision/apiv1"
+	"github.com/golang/protobuf/jsonpb"
+	"golang.org/x/net/context"
+	pb "google.golang.org/genproto/googleapis/cloud/vision/v1"
+)
+
+// TextDetector extracts the text printed on a workout image.
+type TextDetector interface {
+	DetectText(ctx context.Context, image *workoutImage) (*pb.TextAnnotation, error)
+	Close() error
+}
+
+// newTextDetector creates the detector backend named by kind.
+func newTextDetector(ctx context.Context, kind string, fixtureDir string) (TextDetector, error) {
+	switch kind {
+	case "vision":
+		return newVisionDetector(ctx)
+	case "fixture":
+		return newFixtureDetector(fixtureDir)
+	}
+	return nil, fmt.Errorf("unknown text detector %q", kind)
+}
+
+// visionDetector detects text using the Google Vision API.
+type visionDetector struct {
+	client *vision.ImageAnnotatorClient
+}
+
+func newVisionDetector(ctx context.Context) (*visionDetector, error) {
+	client, err := vision.NewImageAnnotatorClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &visionDetector{client: client}, nil
+}
+
+func (d *visionDetector) DetectText(ctx context.Context, image *workoutImage) (*pb.TextAnnotation, error) {
+	visionImage, err := vision.NewImageFromReader(bytes.NewReader(image.Content))
+	if err != nil {
+		return nil, err
+	}
+	return d.client.DetectDocumentText(ctx, visionImage, nil)
+}
+
+func (d *visionDetector) Close() error {
+	return d.client.Close()
+}
+
+// fixtureDetector returns canned OCR output for an image URL, so the service can
+// run without GCP credentials. Each fixture lives in dir, named after the
+// Firestore-safe form of the image URL on darebee.com, even when running
+// against a mirror. A ".json" fixture holds a Vision annotation including
+// layout, in the shape of a REST response's fullTextAnnotation; a ".txt"
+// fixture holds only text. Fixtures may be recorded or written by hand.
+type fixtureDetector struct {
+	dir string
+}
+
+func newFixtureDetector(dir string) (*fixtureDetector, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("fixture path %s is not a directory", dir)
+	}
+	return &fixtureDetector{dir: dir}, nil
+}
+
+func (d *fixtureDetector) fixturePath(imageURL string, extension string) string {
+	if mirrored := strings.TrimPrefix(imageURL, upstream("")); mirrored != imageURL {
+		imageURL = defaultUpstreamURL + mirrored
+	}
+	return filepath.Join(d.dir, getFirestoreName(imageURL)+extension)
+}
+
+func (d *fixtureDetector) DetectText(ctx context.Context, image *workoutImage) (*pb.TextAnnotation, error) {
+	imageURL := image.URL
+	recorded, err := os.Open(d.fixturePath(imageURL, ".json"))
+	if err == nil {
+		defer recorded.Close()
+		annotation := &pb.TextAnnotation{}
+		if err = jsonpb.Unmarshal(recorded, annotation); err != nil {
+			return nil, fmt.Errorf("invalid OCR fixture for %s: %v", imageURL, err)
+		}
+		return annotation, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	text, err := ioutil.ReadFile(d.fixturePath(imageURL, ".txt"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no OCR fixture recorded for %s", imageURL)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pb.TextAnnotation{Text: string(text)}, nil
+}
+
+func (d *fixtureDetector) Close() error {
+	return nil
+}
+
+// ocrPipeline fetches a workout image, optionally cleans it up and detects its text.
+type ocrPipeline struct {
+	fetcher    *imageFetcher
+	preprocess preprocessOptions
+	detector   TextDetector
+}
+
+// prepareImage downloads imageURL and applies opts to it, exactly as it would be
+// sent to the detector.
+func (p *ocrPipeline) prepareImage(ctx context.Context, imageURL string, opts preprocessOptions) (*workoutImage, error) {
+	image, err := p.fetcher.fetch(ctx, imageURL)
+	if err != nil {
+		return nil, err
+	}
+	return preprocessImage(image, opts)
+}
+
+func (p *ocrPipeline) detectText(ctx context.Context, imageURL string) (*pb.TextAnnotation, error) {
+	image, err := p.prepareImage(ctx, imageURL, p.preprocess)
+	if err != nil {
+		return nil, err
+	}
+	annotation, err := p.detector.DetectText(ctx, image)
+	if err != nil {
+		return nil, fmt.Errorf("text detection failed for %s: %v", imageURL, err)
+	}
+	return annotation, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"gotest.tools/assert"
+)
+
+func TestNewTextDetector(t *testing.T) {
+	t.Run("fixture backend", func(t *testing.T) {
+		detector, err := newTextDetector(context.Background(), "fixture", "testdata/ocr")
+		assert.NilError(t, err)
+		_, ok := detector.(*fixtureDetector)
+		assert.Assert(t, ok)
+	})
+	t.Run("missing fixture directory", func(t *testing.T) {
+		_, err := newTextDetector(context.Background(), "fixture", "testdata/does-not-exist")
+		assert.Assert(t, err != nil)
+	})
+	t.Run("unknown backend", func(t *testing.T) {
+		_, err := newTextDetector(context.Background(), "tesseract", "testdata/ocr")
+		assert.Error(t, err, `unknown text detector "tesseract"`)
+	})
+}
+
+func TestFixtureDetector(t *testing.T) {
+	detector, err := newFixtureDetector("testdata/ocr")
+	assert.NilError(t, err)
+	t.Run("recorded image", func(t *testing.T) {
+		annotation, err := detector.DetectText(context.Background(), &workoutImage{URL: "https://darebee.com/images/programs/foundation/web/day03.jpg"})
+		assert.NilError(t, err)
+		var videoNames []string
+		for _, line := range getLinesForAnnotation(annotation) {
+			if videoName := getVideoName(line.Text); videoName != "" {
+				videoNames = append(videoNames, videoName)
+			}
+		}
+		assert.DeepEqual(t, []string{"knee-strikes", "low-front-kicks", "overhead-punches", "jab-jab-cross"}, videoNames)
+	})
+	// day04.json is a synthetic layout fixture built by hand in the
+	// fullTextAnnotation format, not a recording of the Vision API.
+	t.Run("synthetic annotation with layout", func(t *testing.T) {
+		annotation, err := detector.DetectText(context.Background(), &workoutImage{URL: "https://darebee.com/images/programs/foundation/web/day04.jpg"})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, []string{
+			"Foundation",
+			"Day 4 Fighter",
+			"20 knee strikes",
+			"20 low front kicks",
+			"20 overhead punches",
+			"20 side-to-side chops",
+			"10 push-ups",
+			"20 skiers",
+			"o darebee.com",
+		}, lineTexts(getLinesForAnnotation(annotation)))
+	})
+	t.Run("recorded image served by a mirror", func(t *testing.T) {
+		defer useUpstream("http://localhost:8000")()
+		annotation, err := detector.DetectText(context.Background(), &workoutImage{URL: "http://localhost:8000/images/programs/foundation/web/day03.jpg"})
+		assert.NilError(t, err)
+		assert.Equal(t, "Foundation", getLinesForAnnotation(annotation)[0].Text)
+	})
+	t.Run("unrecorded image", func(t *testing.T) {
+		_, err := detector.DetectText(context.Background(), &workoutImage{URL: "https://darebee.com/images/programs/foundation/web/day99.jpg"})
+		assert.Error(t, err, "no OCR fixture recorded for https://darebee.com/images/programs/foundation/web/day99.jpg")
+	})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+const exerciseCacheCollection = "exerciseCache"
+
+// exerciseCacheEntry is how a guessed slug resolved, so the same exercise in
+// another workout doesn't have its page fetched again.
+type exerciseCacheEntry struct {
+	// Slug is the exercise the guess resolved to, which differs from the
+	// guess if it was found by a site search.
+	Slug       string           `json:"slug" firestore:"slug"`
+	MatchScore float64          `json:"matchScore" firestore:"matchScore"`
+	Status     string           `json:"status" firestore:"status"`
+	Error      string           `json:"error,omitempty" firestore:"error,omitempty"`
+	ResolvedBy string           `json:"resolvedBy" firestore:"resolvedBy"`
+	EmbedURL   string           `json:"embedURL" firestore:"embedURL"`
+	Media      *exerciseMedia   `json:"media,omitempty" firestore:"media,omitempty"`
+	Details    *exerciseDetails `json:"details,omitempty" firestore:"details,omitempty"`
+	Expires    time.Time        `json:"expires" firestore:"expires"`
+}
+
+// exerciseCacheStore persists exercise cache entries by guessed slug. get
+// returns nil if there is no entry.
+type exerciseCacheStore interface {
+	get(ctx context.Context, guess string) (*exerciseCacheEntry, error)
+	set(ctx context.Context, guess string, entry exerciseCacheEntry) error
+}
+
+// exerciseCache remembers resolved exercises across workouts. It is disabled
+// until it has a store, or if EXERCISE_CACHE_TTL is 0.
+type exerciseCache struct {
+	store exerciseCacheStore
+}
+
+// slugCache is consulted before fetching any exercise page.
+var slugCache = &exerciseCache{}
+
+// ttl is how long an exercise resolved with status is cached: found videos for
+// a long while, missing pages (the negative entries) and pages without a video
+// for less, and upstream failures not at all.
+func (c *exerciseCache) ttl(status string) time.Duration {
+	switch status {
+	case statusResolved:
+		return *exerciseCacheTTL
+	case statusNotFound:
+		return *notFoundCacheTTL
+	case statusNoMedia:
+		return *noMediaCacheTTL
+	}
+	return 0
+}
+
+func (c *exerciseCache) enabled() bool {
+	return c.store != nil && *exerciseCacheTTL > 0
+}
+
+// get fills in e from the cache, reporting whether there was an unexpired
+// entry for its slug. Cache failures are logged and treated as misses.
+func (c *exerciseCache) get(ctx context.Context, e *exercise) bool {
+	if !c.enabled() {
+		return false
+	}
+	entry, err := c.store.get(ctx, e.Slug)
+	if err != nil {
+		log.Printf("Failed reading %s from the exercise cache: %v", e.Slug, err)
+		return false
+	}
+	if entry == nil || time.Now().After(entry.Expires) {
+		return false
+	}
+	if entry.Slug != e.Slug {
+		e.Slug, e.MatchScore = entry.Slug, entry.MatchScore
+	}
+	e.Status, e.Error, e.ResolvedBy = entry.Status, entry.Error, entry.ResolvedBy
+	e.EmbedURL, e.Media, e.Details = entry.EmbedURL, entry.Media, entry.Details
+	return true
+}
+
+// set caches how e resolved under the slug it was guessed as.
+func (c *exerciseCache) set(ctx context.Context, guess string, e exercise) {
+	ttl := c.ttl(e.Status)
+	if !c.enabled() || ttl <= 0 {
+		return
+	}
+	entry := exerciseCacheEntry{
+		Slug:       e.Slug,
+		Status:     e.Status,
+		Error:      e.Error,
+		ResolvedBy: e.ResolvedBy,
+		EmbedURL:   e.EmbedURL,
+		Media:      e.Media,
+		Details:    e.Details,
+		Expires:    time.Now().Add(ttl),
+	}
+	if e.Slug != guess {
+		entry.MatchScore = e.MatchScore
+	}
+	if err := c.store.set(ctx, guess, entry); err != nil {
+		log.Printf("Failed saving %s to the exercise cache: %v", guess, err)
+	}
+}
+
+// firestoreExerciseCacheStore keeps the exercise cache in Firestore, one
+// document per guessed slug, so it is shared by every instance.
+type firestoreExerciseCacheStore struct {
+	client *firestore.Client
+}
+
+func (s *firestoreExerciseCacheStore) get(ctx context.Context, guess string) (*exerciseCacheEntry, error) {
+	doc, err := s.client.Collection(exerciseCacheCollection).Doc(guess).Get(ctx)
+	if err != nil && grpc.Code(err) != codes.NotFound {
+		return nil, err
+	}
+	if !doc.Exists() {
+		return nil, nil
+	}
+	entry := &exerciseCacheEntry{}
+	if err := doc.DataTo(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (s *firestoreExerciseCacheStore) set(ctx context.Context, guess string, entry exerciseCacheEntry) error {
+	_, err := s.client.Collection(exerciseCacheCollection).Doc(guess).Set(ctx, entry)
+	return err
+}
+
+// memoryExerciseCacheStore keeps the exercise cache in memory, for running
+// without Firestore.
+type memoryExerciseCacheStore struct {
+	mu      sync.Mutex
+	entries map[string]exerciseCacheEntry
+}
+
+func (s *memoryExerciseCacheStore) get(ctx context.Context, guess string) (*exerciseCacheEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[guess]
+	if !ok {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+func (s *memoryExerciseCacheStore) set(ctx context.Context, guess string, entry exerciseCacheEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries == nil {
+		s.entries = map[string]exerciseCacheEntry{}
+	}
+	s.entries[guess] = entry
+	return nil
+}
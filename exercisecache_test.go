@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"gotest.tools/assert"
+)
+
+// useExerciseCache caches exercises in store until the returned function is
+// called.
+func useExerciseCache(store exerciseCacheStore) func() {
+	original := slugCache.store
+	slugCache.store = store
+	return func() { slugCache.store = original }
+}
+
+func TestExerciseCache(t *testing.T) {
+	var mu sync.Mutex
+	fetches := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetches[r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/exercises/broken-exercise.html":
+			http.Error(w, "oops", http.StatusInternalServerError)
+		case "/exercises/no-video.html":
+			fmt.Fprint(w, `<p>Coming soon</p>`)
+		case "/exercises/made-up.html":
+			http.NotFound(w, r)
+		case "/search.html":
+			fmt.Fprint(w, `<dl class="search-results"></dl>`)
+		default:
+			fmt.Fprint(w, `<iframe src="https://www.youtube.com/embed/abc123?rel=0"></iframe>`)
+		}
+	}))
+	defer server.Close()
+	defer useUpstream(server.URL)()
+	defer useOutbound(newUpstreamTransport(server.Client().Transport, upstreamOptions{}))()
+	store := &memoryExerciseCacheStore{}
+	defer useExerciseCache(store)()
+	catalog := newExerciseCatalog(nil)
+
+	resolve := func(slug string) exercise {
+		exercises := []exercise{{Name: slug, Slug: slug}}
+		resolveExercises(context.Background(), exercises, catalog, 1)
+		return exercises[0]
+	}
+
+	t.Run("found videos are shared across workouts", func(t *testing.T) {
+		first, second := resolve("burpees"), resolve("burpees")
+		assert.Equal(t, 1, fetches["/exercises/burpees.html"])
+		assert.DeepEqual(t, first, second)
+		assert.Equal(t, statusResolved, second.Status)
+		assert.Equal(t, "abc123", second.EmbedURL)
+		assert.Equal(t, resolvedByPage, second.ResolvedBy)
+		assert.Assert(t, store.entries["burpees"].Expires.After(time.Now().Add(*exerciseCacheTTL-time.Minute)))
+	})
+	t.Run("missing pages are cached as negative entries", func(t *testing.T) {
+		resolve("made-up")
+		e := resolve("made-up")
+		assert.Equal(t, 1, fetches["/exercises/made-up.html"])
+		assert.Equal(t, statusNotFound, e.Status)
+		assert.Assert(t, store.entries["made-up"].Expires.Before(time.Now().Add(*notFoundCacheTTL+time.Minute)))
+	})
+	t.Run("pages without a video are cached", func(t *testing.T) {
+		resolve("no-video")
+		assert.Equal(t, statusNoMedia, resolve("no-video").Status)
+		assert.Equal(t, 1, fetches["/exercises/no-video.html"])
+	})
+	t.Run("upstream failures are not cached", func(t *testing.T) {
+		resolve("broken-exercise")
+		assert.Equal(t, statusUpstreamError, resolve("broken-exercise").Status)
+		assert.Equal(t, 2, fetches["/exercises/broken-exercise.html"])
+		_, ok := store.entries["broken-exercise"]
+		assert.Assert(t, !ok)
+	})
+	t.Run("expired entries are fetched again", func(t *testing.T) {
+		entry := store.entries["burpees"]
+		entry.Expires = time.Now().Add(-time.Second)
+		store.entries["burpees"] = entry
+		resolve("burpees")
+		assert.Equal(t, 2, fetches["/exercises/burpees.html"])
+	})
+	t.Run("search results are cached under the guess", func(t *testing.T) {
+		store.set(context.Background(), "side-chops", exerciseCacheEntry{
+			Slug:       "side-to-side-chops",
+			MatchScore: 0.8,
+			Status:     statusResolved,
+			ResolvedBy: resolvedBySearch,
+			EmbedURL:   "xyz789",
+			Expires:    time.Now().Add(time.Hour),
+		})
+		e := resolve("side-chops")
+		assert.Equal(t, 0, fetches["/exercises/side-chops.html"])
+		assert.Equal(t, "side-to-side-chops", e.Slug)
+		assert.Equal(t, 0.8, e.MatchScore)
+		assert.Equal(t, "xyz789", e.EmbedURL)
+	})
+}
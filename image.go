@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// maxImageSize guards against reading something other than a workout graphic
+// into memory; Darebee images are well under 1MB.
+const maxImageSize = 10 << 20
+
+// workoutImage is a downloaded workout graphic ready for text detection.
+type workoutImage struct {
+	URL     string
+	Content []byte
+}
+
+// imageFetchError is returned when the workout image itself could not be
+// downloaded, as opposed to text detection failing on it.
+type imageFetchError struct {
+	URL        string
+	StatusCode int
+	Err        error
+}
+
+func (e *imageFetchError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("failed to fetch image %s: %v", e.URL, e.Err)
+	}
+	return fmt.Sprintf("failed to fetch image %s: upstream returned %d", e.URL, e.StatusCode)
+}
+
+// imageFetcher downloads workout images and keeps the most recent ones in
+// memory, so retries after an OCR failure don't hit Darebee again.
+type imageFetcher struct {
+	client    *http.Client
+	cacheSize int
+
+	mu    sync.Mutex
+	cache map[string][]byte
+	order []string
+}
+
+func newImageFetcher(client *http.Client, cacheSize int) *imageFetcher {
+	return &imageFetcher{client: client, cacheSize: cacheSize, cache: map[string][]byte{}}
+}
+
+func (f *imageFetcher) fetch(ctx context.Context, imageURL string) (*workoutImage, error) {
+	if content := f.cached(imageURL); content != nil {
+		return &workoutImage{URL: imageURL, Content: content}, nil
+	}
+	req, err := http.NewRequest("GET", imageURL, nil)
+	if err != nil {
+		return nil, &imageFetchError{URL: imageURL, Err: err}
+	}
+	resp, err := f.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, &imageFetchError{URL: imageURL, Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &imageFetchError{URL: imageURL, StatusCode: resp.StatusCode}
+	}
+	// a missing image is sometimes served as an error page rather than a 404
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		return nil, &imageFetchError{URL: imageURL, StatusCode: http.StatusNotFound, Err: errors.New("upstream returned a page instead of an image")}
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxImageSize+1))
+	if err != nil {
+		return nil, &imageFetchError{URL: imageURL, StatusCode: resp.StatusCode, Err: err}
+	}
+	if len(content) > maxImageSize {
+		return nil, &imageFetchError{URL: imageURL, StatusCode: resp.StatusCode, Err: errors.New("image too large")}
+	}
+	f.store(imageURL, content)
+	return &workoutImage{URL: imageURL, Content: content}, nil
+}
+
+func (f *imageFetcher) cached(imageURL string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cache[imageURL]
+}
+
+func (f *imageFetcher) store(imageURL string, content []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.cache[imageURL]; ok || f.cacheSize <= 0 {
+		return
+	}
+	if len(f.order) >= f.cacheSize {
+		delete(f.cache, f.order[0])
+		f.order = f.order[1:]
+	}
+	f.cache[imageURL] = content
+	f.order = append(f.order, imageURL)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+	"gotest.tools/assert"
+)
+
+func TestImageFetcher(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/images/programs/foundation/web/day31.jpg" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte("<html>Page not found</html>"))
+			return
+		}
+		if r.URL.Path != "/images/programs/foundation/web/day03.jpg" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("jpeg bytes"))
+	}))
+	defer server.Close()
+	fetcher := newImageFetcher(server.Client(), 1)
+
+	t.Run("downloads image bytes", func(t *testing.T) {
+		image, err := fetcher.fetch(context.Background(), server.URL+"/images/programs/foundation/web/day03.jpg")
+		assert.NilError(t, err)
+		assert.Equal(t, "jpeg bytes", string(image.Content))
+		assert.Equal(t, 1, requests)
+	})
+	t.Run("serves repeat requests from cache", func(t *testing.T) {
+		_, err := fetcher.fetch(context.Background(), server.URL+"/images/programs/foundation/web/day03.jpg")
+		assert.NilError(t, err)
+		assert.Equal(t, 1, requests)
+	})
+	t.Run("reports missing image as fetch error", func(t *testing.T) {
+		_, err := fetcher.fetch(context.Background(), server.URL+"/images/programs/foundation/web/day99.jpg")
+		fetchErr, ok := err.(*imageFetchError)
+		assert.Assert(t, ok)
+		assert.Equal(t, http.StatusNotFound, fetchErr.StatusCode)
+	})
+	t.Run("reports error page served instead of image as missing", func(t *testing.T) {
+		_, err := fetcher.fetch(context.Background(), server.URL+"/images/programs/foundation/web/day31.jpg")
+		fetchErr, ok := err.(*imageFetchError)
+		assert.Assert(t, ok)
+		assert.Equal(t, http.StatusNotFound, fetchErr.StatusCode)
+	})
+	t.Run("reports unreachable host as fetch error", func(t *testing.T) {
+		_, err := fetcher.fetch(context.Background(), "http://127.0.0.1:1/day03.jpg")
+		_, ok := err.(*imageFetchError)
+		assert.Assert(t, ok)
+	})
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	pb "google.golang.org/genproto/googleapis/cloud/vision/v1"
+)
+
+// gutterFactor is how many line heights of empty horizontal space separate two
+// columns of text. Gaps narrower than this (e.g. between a rep count and the
+// exercise name) keep the text on the same line.
+const gutterFactor = 2
+
+// box is an axis-aligned bounding box in image pixels.
+type box struct {
+	MinX, MinY, MaxX, MaxY int32
+}
+
+func boxFromPoly(poly *pb.BoundingPoly) box {
+	vertices := poly.GetVertices()
+	if len(vertices) == 0 {
+		return box{}
+	}
+	b := box{MinX: vertices[0].GetX(), MinY: vertices[0].GetY(), MaxX: vertices[0].GetX(), MaxY: vertices[0].GetY()}
+	for _, v := range vertices[1:] {
+		b = b.union(box{MinX: v.GetX(), MinY: v.GetY(), MaxX: v.GetX(), MaxY: v.GetY()})
+	}
+	return b
+}
+
+func (b box) union(other box) box {
+	if other.MinX < b.MinX {
+		b.MinX = other.MinX
+	}
+	if other.MinY < b.MinY {
+		b.MinY = other.MinY
+	}
+	if other.MaxX > b.MaxX {
+		b.MaxX = other.MaxX
+	}
+	if other.MaxY > b.MaxY {
+		b.MaxY = other.MaxY
+	}
+	return b
+}
+
+func (b box) height() int32 {
+	return b.MaxY - b.MinY
+}
+
+func (b box) centerY() int32 {
+	return (b.MinY + b.MaxY) / 2
+}
+
+// ocrLine is a single line of text as it appears visually on the workout image.
+// Confidence is the lowest word confidence Vision reported on the line, since a
+// single misread word is enough to break the exercise name.
+type ocrLine struct {
+	Text       string
+	Box        box
+	Confidence float32
+}
+
+// getLinesForAnnotation turns a Vision document annotation into lines of text in
+// reading order. Darebee graphics are often laid out in columns, which the flat
+// annotation text interleaves, so the lines are rebuilt from the paragraph
+// structure and bounding boxes and ordered column by column.
+func getLinesForAnnotation(annotation *pb.TextAnnotation) []ocrLine {
+	if len(annotation.GetPages()) == 0 {
+		// no layout information (e.g. recorded text fixtures), so trust the text
+		// order and treat it as certain
+		var lines []ocrLine
+		for _, text := range strings.Split(annotation.GetText(), "\n") {
+			lines = append(lines, ocrLine{Text: text, Confidence: 1})
+		}
+		return lines
+	}
+	var lines []ocrLine
+	for _, page := range annotation.GetPages() {
+		var pageLines []ocrLine
+		for _, block := range page.GetBlocks() {
+			for _, paragraph := range block.GetParagraphs() {
+				pageLines = append(pageLines, getLinesForParagraph(paragraph)...)
+			}
+		}
+		lines = append(lines, orderLines(pageLines, gutterFactor*medianHeight(pageLines))...)
+	}
+	return lines
+}
+
+// getLinesForParagraph splits a paragraph into lines using the breaks Vision
+// detected after each word.
+func getLinesForParagraph(paragraph *pb.Paragraph) []ocrLine {
+	var lines []ocrLine
+	var text strings.Builder
+	var lineBox box
+	var confidence float32
+	for _, word := range paragraph.GetWords() {
+		wordBox := boxFromPoly(word.GetBoundingBox())
+		if text.Len() == 0 {
+			lineBox = wordBox
+			confidence = word.GetConfidence()
+		} else {
+			lineBox = lineBox.union(wordBox)
+			if word.GetConfidence() < confidence {
+				confidence = word.GetConfidence()
+			}
+		}
+		var breakType pb.TextAnnotation_DetectedBreak_BreakType
+		for _, symbol := range word.GetSymbols() {
+			text.WriteString(symbol.GetText())
+			breakType = symbol.GetProperty().GetDetectedBreak().GetType()
+		}
+		switch breakType {
+		case pb.TextAnnotation_DetectedBreak_SPACE, pb.TextAnnotation_DetectedBreak_SURE_SPACE:
+			text.WriteString(" ")
+		case pb.TextAnnotation_DetectedBreak_EOL_SURE_SPACE, pb.TextAnnotation_DetectedBreak_LINE_BREAK:
+			lines = append(lines, ocrLine{Text: strings.TrimSpace(text.String()), Box: lineBox, Confidence: confidence})
+			text.Reset()
+		}
+	}
+	if text.Len() > 0 {
+		lines = append(lines, ocrLine{Text: strings.TrimSpace(text.String()), Box: lineBox, Confidence: confidence})
+	}
+	return lines
+}
+
+func medianHeight(lines []ocrLine) int32 {
+	if len(lines) == 0 {
+		return 0
+	}
+	heights := make([]int, len(lines))
+	for i, line := range lines {
+		heights[i] = int(line.Box.height())
+	}
+	sort.Ints(heights)
+	return int32(heights[len(heights)/2])
+}
+
+// orderLines puts lines into reading order with a recursive XY-cut: columns
+// separated by a gutter of at least minGutter pixels are read one after the
+// other, and within a column lines are read top to bottom. Fragments that sit
+// side by side on the same row (such as a count and a name detected as separate
+// paragraphs) are joined into a single line.
+func orderLines(lines []ocrLine, minGutter int32) []ocrLine {
+	if len(lines) <= 1 {
+		return lines
+	}
+	if columns := splitColumns(lines, minGutter); len(columns) > 1 {
+		var ordered []ocrLine
+		for _, column := range columns {
+			ordered = append(ordered, orderLines(column, minGutter)...)
+		}
+		return ordered
+	}
+	rows := splitRows(lines)
+	if len(rows) == 1 {
+		return joinRow(rows[0])
+	}
+	// Group consecutive rows that share a column gutter, so a multi-column
+	// section is split into columns before it is split into rows. Rows that
+	// span the gutter (titles, footers) end the section.
+	var ordered []ocrLine
+	section := rows[0]
+	for _, row := range rows[1:] {
+		merged := append(append([]ocrLine{}, section...), row...)
+		if len(splitColumns(merged, minGutter)) > 1 {
+			section = merged
+			continue
+		}
+		ordered = append(ordered, orderLines(section, minGutter)...)
+		section = row
+	}
+	return append(ordered, orderLines(section, minGutter)...)
+}
+
+// splitColumns splits lines wherever there is a vertical strip of at least
+// minGutter pixels that no line crosses.
+func splitColumns(lines []ocrLine, minGutter int32) [][]ocrLine {
+	sorted := append([]ocrLine{}, lines...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Box.MinX < sorted[j].Box.MinX })
+	var columns [][]ocrLine
+	start := 0
+	right := sorted[0].Box.MaxX
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Box.MinX-right >= minGutter {
+			columns = append(columns, sorted[start:i])
+			start = i
+		}
+		if sorted[i].Box.MaxX > right {
+			right = sorted[i].Box.MaxX
+		}
+	}
+	return append(columns, sorted[start:])
+}
+
+// splitRows splits lines wherever there is a horizontal strip that no line crosses.
+func splitRows(lines []ocrLine) [][]ocrLine {
+	sorted := append([]ocrLine{}, lines...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Box.MinY < sorted[j].Box.MinY })
+	var rows [][]ocrLine
+	start := 0
+	bottom := sorted[0].Box.MaxY
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Box.MinY > bottom {
+			rows = append(rows, sorted[start:i])
+			start = i
+		}
+		if sorted[i].Box.MaxY > bottom {
+			bottom = sorted[i].Box.MaxY
+		}
+	}
+	return append(rows, sorted[start:])
+}
+
+// joinRow merges the fragments of a row into lines, left to right. A tall
+// fragment can pull two stacked lines into the same row, so fragments are only
+// joined when their vertical centres line up.
+func joinRow(row []ocrLine) []ocrLine {
+	sorted := append([]ocrLine{}, row...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Box.centerY() < sorted[j].Box.centerY() })
+	var groups [][]ocrLine
+	for _, fragment := range sorted {
+		last := len(groups) - 1
+		if last >= 0 {
+			lineBox := groups[last][0].Box
+			if c := fragment.Box.centerY(); c >= lineBox.MinY && c <= lineBox.MaxY {
+				groups[last] = append(groups[last], fragment)
+				continue
+			}
+		}
+		groups = append(groups, []ocrLine{fragment})
+	}
+	var lines []ocrLine
+	for _, group := range groups {
+		sort.SliceStable(group, func(i, j int) bool { return group[i].Box.MinX < group[j].Box.MinX })
+		line := group[0]
+		for _, fragment := range group[1:] {
+			line.Text += " " + fragment.Text
+			line.Box = line.Box.union(fragment.Box)
+			if fragment.Confidence < line.Confidence {
+				line.Confidence = fragment.Confidence
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	pb "google.golang.org/genproto/googleapis/cloud/vision/v1"
+	"gotest.tools/assert"
+)
+
+// testParagraph builds a Vision paragraph for text starting at (x, y), where
+// each character is 10px wide and 20px tall.
+func testParagraph(x int32, y int32, text string) *pb.Paragraph {
+	paragraph := &pb.Paragraph{}
+	words := strings.Fields(text)
+	for i, word := range words {
+		w := &pb.Word{BoundingBox: testPoly(x, y, x+int32(10*len(word)), y+20)}
+		for j, char := range word {
+			symbol := &pb.Symbol{Text: string(char)}
+			if j == len(word)-1 {
+				breakType := pb.TextAnnotation_DetectedBreak_SPACE
+				if i == len(words)-1 {
+					breakType = pb.TextAnnotation_DetectedBreak_LINE_BREAK
+				}
+				symbol.Property = &pb.TextAnnotation_TextProperty{
+					DetectedBreak: &pb.TextAnnotation_DetectedBreak{Type: breakType},
+				}
+			}
+			w.Symbols = append(w.Symbols, symbol)
+		}
+		paragraph.Words = append(paragraph.Words, w)
+		x += int32(10*len(word)) + 10
+	}
+	return paragraph
+}
+
+func testPoly(minX int32, minY int32, maxX int32, maxY int32) *pb.BoundingPoly {
+	return &pb.BoundingPoly{Vertices: []*pb.Vertex{{X: minX, Y: minY}, {X: maxX, Y: minY}, {X: maxX, Y: maxY}, {X: minX, Y: maxY}}}
+}
+
+func testAnnotation(paragraphs ...*pb.Paragraph) *pb.TextAnnotation {
+	return &pb.TextAnnotation{Pages: []*pb.Page{{Blocks: []*pb.Block{{Paragraphs: paragraphs}}}}}
+}
+
+func lineTexts(lines []ocrLine) []string {
+	var texts []string
+	for _, line := range lines {
+		texts = append(texts, line.Text)
+	}
+	return texts
+}
+
+func TestGetLinesForAnnotation(t *testing.T) {
+	t.Run("text only", func(t *testing.T) {
+		lines := getLinesForAnnotation(&pb.TextAnnotation{Text: "Foundation\n20 knee strikes"})
+		assert.DeepEqual(t, []string{"Foundation", "20 knee strikes"}, lineTexts(lines))
+	})
+	t.Run("paragraph line breaks", func(t *testing.T) {
+		lines := getLinesForParagraph(testParagraph(0, 0, "20 jab + jab + cross"))
+		assert.DeepEqual(t, []string{"20 jab + jab + cross"}, lineTexts(lines))
+	})
+	t.Run("line confidence is the lowest word confidence", func(t *testing.T) {
+		paragraph := testParagraph(0, 0, "20 jumpinq jacks")
+		paragraph.Words[0].Confidence = 0.99
+		paragraph.Words[1].Confidence = 0.42
+		paragraph.Words[2].Confidence = 0.97
+		lines := getLinesForParagraph(paragraph)
+		assert.Equal(t, float32(0.42), lines[0].Confidence)
+	})
+	t.Run("two columns read one after the other", func(t *testing.T) {
+		// paragraphs are listed in the row-by-row order Vision tends to return
+		lines := getLinesForAnnotation(testAnnotation(
+			testParagraph(0, 0, "Day 3 Fighter"),
+			testParagraph(0, 40, "20 knee strikes"),
+			testParagraph(400, 40, "20 squats"),
+			testParagraph(0, 80, "20 low front kicks"),
+			testParagraph(400, 80, "10 push-ups"),
+			testParagraph(400, 120, "o darebee.com"),
+		))
+		assert.DeepEqual(t, []string{
+			"Day 3 Fighter",
+			"20 knee strikes",
+			"20 low front kicks",
+			"20 squats",
+			"10 push-ups",
+			"o darebee.com",
+		}, lineTexts(lines))
+	})
+	t.Run("counts detected apart from names", func(t *testing.T) {
+		lines := getLinesForAnnotation(testAnnotation(
+			testParagraph(0, 0, "20"),
+			testParagraph(0, 40, "10"),
+			testParagraph(30, 0, "knee strikes"),
+			testParagraph(30, 40, "bridges"),
+		))
+		assert.DeepEqual(t, []string{"20 knee strikes", "10 bridges"}, lineTexts(lines))
+	})
+}
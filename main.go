@@ -1,13 +1,12 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"regexp"
 	"strconv"
 	"strings"
-	"cloud.google.com/go/vision/apiv1"
 	"golang.org/x/net/context"
 	"flag"
 	"net/url"
@@ -17,148 +16,383 @@ import (
 	"errors"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"os"
+	"sync"
+	"time"
 )
 
 const firestoreCollection = "cache"
 const firestoreKey = "exercises"
 var docNotFoundError = errors.New("document not found")
 
-func detectText(imageURL string) (string, error) {
-	ctx := context.Background()
-	client, err := vision.NewImageAnnotatorClient(ctx)
+// defaultUpstreamURL is the Darebee site everything is fetched from, unless
+// pointed at a mirror.
+const defaultUpstreamURL = "https://darebee.com"
+
+var upstreamURL = flag.String("upstream", getEnv("UPSTREAM_URL", defaultUpstreamURL), "base URL of the Darebee site to fetch images and pages from, e.g. a local mirror")
+var upstreamTimeout = flag.Duration("upstream-timeout", getEnvDuration("UPSTREAM_TIMEOUT", defaultUpstreamOptions().Timeout), "timeout for each attempt at a request to the upstream")
+var upstreamRetries = flag.Int("upstream-retries", getEnvInt("UPSTREAM_RETRIES", defaultUpstreamOptions().Retries), "how many times to retry upstream requests that fail with a network or server error")
+var upstreamRate = flag.Float64("upstream-rate", getEnvFloat("UPSTREAM_RATE", defaultUpstreamOptions().Rate), "maximum requests per second to each upstream host, 0 for no limit")
+var partialCacheTTL = flag.Duration("partial-cache-ttl", getEnvDuration("PARTIAL_CACHE_TTL", 10*time.Minute), "how long to cache results where some exercise lookups failed")
+var searchThreshold = flag.Float64("search-threshold", getEnvFloat("SEARCH_THRESHOLD", 0.6), "similarity a site search result needs to the guessed exercise to be used, above 1 to disable searching")
+var noMediaCacheTTL = flag.Duration("no-media-cache-ttl", getEnvDuration("NO_MEDIA_CACHE_TTL", 24*time.Hour), "how long to cache results with exercise pages that have no video yet")
+var exerciseCacheTTL = flag.Duration("exercise-cache-ttl", getEnvDuration("EXERCISE_CACHE_TTL", 7*24*time.Hour), "how long to cache an exercise's video for other workouts, 0 to disable the exercise cache")
+var notFoundCacheTTL = flag.Duration("not-found-cache-ttl", getEnvDuration("NOT_FOUND_CACHE_TTL", 24*time.Hour), "how long to remember that an exercise page doesn't exist")
+var resolveWorkers = flag.Int("resolve-workers", getEnvInt("RESOLVE_WORKERS", 4), "how many exercise pages to fetch at once")
+var detectorKind = flag.String("detector", getEnv("DETECTOR", "vision"), "text detector backend: vision or fixture")
+var fixtureDir = flag.String("fixtures", getEnv("OCR_FIXTURES", "testdata/ocr"), "directory of OCR fixtures used by the fixture detector")
+var preprocessSpec = flag.String("preprocess", getEnv("PREPROCESS", ""), "image preprocessing steps before OCR, e.g. crop=0:0.3:1:1,grayscale,threshold=160,scale=2")
+var matchThreshold = flag.Float64("match-threshold", getEnvFloat("MATCH_THRESHOLD", 0.8), "similarity needed to correct an exercise name to a known exercise")
+var catalogFile = flag.String("catalog", getEnv("CATALOG_FILE", ""), "JSON file holding the exercise catalog (default: Firestore)")
+var crawl = flag.Bool("crawl", false, "crawl the exercise library into the catalog and exit")
+var libraryURL = flag.String("library-url", getEnv("LIBRARY_URL", ""), "first index page of the exercise library (default: exercises.html on the upstream)")
+var crawlMaxPages = flag.Int("crawl-max-pages", 100, "maximum number of library index pages to crawl")
+var cacheKind = flag.String("cache", getEnv("CACHE", "firestore"), "where to cache workout results and exercise lookups: firestore or memory")
+var aliasFile = flag.String("aliases", getEnv("ALIASES_FILE", ""), "JSON file holding exercise aliases (default: Firestore)")
+var aliasReloadInterval = flag.Duration("alias-reload", getEnvDuration("ALIAS_RELOAD", 5*time.Minute), "how often to reload aliases from their store, 0 to disable")
+var adminToken = flag.String("admin-token", getEnv("ADMIN_TOKEN", ""), "bearer token for the admin endpoints, which are disabled if empty")
+var minConfidence = flag.Float64("min-confidence", getEnvFloat("MIN_CONFIDENCE", 0.8), "OCR confidence below which exercises are flagged as possible misreads")
+
+// getEnv returns the value of the environment variable key, or fallback if it is unset.
+func getEnv(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// getEnvFloat is like getEnv for numeric settings; unparseable values are ignored.
+func getEnvFloat(key string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(getEnv(key, ""), 64)
 	if err != nil {
-		return "", err
+		return fallback
+	}
+	return value
+}
+
+// getEnvInt is like getEnv for integers; unparseable values are ignored.
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(getEnv(key, ""))
+	if err != nil {
+		return fallback
 	}
-	image := vision.NewImageFromURI(imageURL)
-	annotations, err := client.DetectDocumentText(ctx, image, nil)
+	return value
+}
+
+// getEnvDuration is like getEnv for durations such as "5m"; unparseable values are ignored.
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(getEnv(key, ""))
 	if err != nil {
-		return "", err
+		return fallback
 	}
-	return annotations.Text, nil
+	return value
 }
 
-var exceptions = map[string]string{
-	"alt-arm-leg-raises": "arm-leg-raises",
-	"lunges-exercise":    "forward-lunges",
+// upstream returns the URL of path on the upstream Darebee site.
+func upstream(path string) string {
+	return strings.TrimSuffix(*upstreamURL, "/") + path
 }
 
 func getVideoName(line string) string {
+	videoName, _ := lookupVideoName(line)
+	return videoName
+}
+
+// exerciseSlug turns an exercise name into the slug of its Darebee page, e.g.
+// "Knee Strikes" into "knee-strikes" and "Squats" into "squats-exercise".
+func exerciseSlug(name string) string {
+	// replace non-word chars with hyphen
+	r := regexp.MustCompile(`[^\w]`)
+	str := r.ReplaceAllString(strings.ToLower(name), "-")
+	// convert any multi hyphen to hyphen (making less sensitive to Google Vision mistakes)
+	r = regexp.MustCompile("-+")
+	str = r.ReplaceAllString(str, "-")
+	// for single word exercises, they append "-exercise" to it
+	if !strings.Contains(str, "-") {
+		str = str + "-exercise"
+	}
+	return str
+}
+
+// lookupVideoName is getVideoName, also reporting whether the slug came from
+// the alias table, which is already a correction and so shouldn't be matched
+// against the catalog again.
+func lookupVideoName(line string) (videoName string, aliased bool) {
 	line = strings.ToLower(line)
 	// extract names only when prefaced with exercise count
-	r := regexp.MustCompile(`^(\d+)\s+(.+)`)
-	matches := r.FindStringSubmatch(line)
-	if len(matches) >= 3 {
+	parsed, ok := parseExerciseLine(line)
+	if ok {
 		// handle "between sets" instruction; not an exercise so skip it
 		if strings.Contains(line, "between") {
-			return ""
-		}
-		// replace non-word chars with hyphen
-		r = regexp.MustCompile(`[^\w]`)
-		str := r.ReplaceAllString(matches[2], "-")
-		// convert any multi hyphen to hyphen (making less sensitive to Google Vision mistakes)
-		r = regexp.MustCompile("-+")
-		str = r.ReplaceAllString(str, "-")
-		// for single word exercises, they append "-exercise" to it
-		if !strings.Contains(str, "-") {
-			str = str + "-exercise"
+			return "", false
 		}
+		str := exerciseSlug(parsed.Name)
 		// check for any exceptional cases
-		if exceptions[str] != "" {
-			return exceptions[str]
+		if target := aliases.lookup(str); target != "" {
+			return target, true
 		}
-		return str
+		return str, false
 	}
-	return ""
+	return "", false
 }
 
-func getImageURL(workout string, day string) (string, error) {
-	workout = strings.ToLower(workout)
-	dayNum, err := strconv.Atoi(day)
+func getVideoURL(name string) string {
+	return upstream(fmt.Sprintf("/exercises/%s.html", name))
+}
+
+// getYoutubeEmbed returns the YouTube video ID embedded in an exercise page.
+// The error is a pageNotFoundError, noMediaError or upstreamUnavailableError
+// if there is no video to be had; a page whose video isn't on YouTube gives
+// "" without an error.
+func getYoutubeEmbed(ctx context.Context, videoURL string) (string, error) {
+	page, err := lookupExercisePage(ctx, videoURL)
 	if err != nil {
 		return "", err
 	}
-	return fmt.Sprintf("https://darebee.com/images/programs/%s/web/day%02d.jpg", workout, dayNum), nil
+	return page.EmbedID, nil
 }
 
-func getVideoURL(name string) string {
-	return fmt.Sprintf("https://darebee.com/exercises/%s.html", name)
+// exercise is an exercise line from the workout graphic with its video. Text is
+// the line as OCR read it; the other fields are parsed from it. MatchScore is
+// how closely Slug matched a known exercise, or 0 if it isn't in the catalog.
+type exercise struct {
+	Name       string  `json:"name"`
+	Text       string  `json:"text"`
+	Quantity   int     `json:"quantity"`
+	Unit       string  `json:"unit"`
+	Side       string  `json:"side,omitempty"`
+	Slug       string  `json:"slug"`
+	MatchScore float64 `json:"matchScore"`
+	EmbedURL   string  `json:"embedURL"`
+	Confidence float32 `json:"confidence"`
+	// Media is the exercise's video; EmbedURL is also set if it is on YouTube.
+	Media *exerciseMedia `json:"media,omitempty"`
+	// Status is how looking up the video went, with the reason in Error if
+	// the upstream failed.
+	Status string `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// ResolvedBy records where the video was found: the catalog, the page for
+	// the guessed slug, or a site search, which also updates Slug and MatchScore.
+	ResolvedBy string `json:"resolvedBy,omitempty"`
+	// Details are from the exercise's page in the exercise library.
+	Details *exerciseDetails `json:"details,omitempty"`
 }
 
-func getYoutubeEmbed(videoURL string) (string, error) {
-	resp, err := http.Get(videoURL)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
+// media returns the exercise's video, including for exercises cached before
+// anything but YouTube was recognised.
+func (e exercise) media() *exerciseMedia {
+	if e.Media != nil {
+		return e.Media
 	}
-	r := regexp.MustCompile(`youtube\.com/embed/([^?]+)\?`)
-	matches := r.FindStringSubmatch(string(body))
-	if len(matches) >= 2 {
-		return matches[1], nil
+	return youtubeMedia(e.EmbedURL)
+}
+
+// fuzzyMatched reports whether the exercise was resolved to a catalog entry
+// whose name differs from what OCR read.
+func (e exercise) fuzzyMatched() bool {
+	return e.MatchScore > 0 && e.MatchScore < 1
+}
+
+// title formats the exercise for display, e.g. "20 reps — knee strikes".
+// Exercises cached before quantities were parsed only have their OCR text.
+func (e exercise) title() string {
+	if e.Unit == "" {
+		return e.Name
 	}
-	return "", nil
+	return workoutExercise{Quantity: e.Quantity, Unit: e.Unit, Side: e.Side}.label() + " — " + e.Name
 }
 
-type exercise struct {
-	Name     string
-	EmbedURL string
+// lowConfidence reports whether the exercise name was probably misread by OCR.
+// A zero confidence means it is unknown (e.g. cached before it was recorded).
+func (e exercise) lowConfidence(threshold float64) bool {
+	return e.Confidence > 0 && float64(e.Confidence) < threshold
 }
 
 type firestoreDoc struct {
 	Exercises []exercise `firestore:"exercises,omitempty"`
+	Workout   *Workout   `firestore:"workout,omitempty"`
+	// ImageURL is where the graphic was found, which is only the URL the
+	// result is cached under if it is published under the usual name.
+	ImageURL string `firestore:"imageURL,omitempty"`
+	// Expires is set for partial results, which are recalculated once it has
+	// passed in the hope that the failed lookups succeed.
+	Expires time.Time `firestore:"expires"`
+}
+
+// expired reports whether the cached result should be recalculated.
+func (doc *firestoreDoc) expired(now time.Time) bool {
+	return !doc.Expires.IsZero() && now.After(doc.Expires)
+}
+
+// cacheExpiry returns when cached exercises should be recalculated. Results
+// where Darebee couldn't be reached are retried soon, and exercises whose page
+// has no video yet are checked again now and then. Missing pages don't change,
+// so those results, like complete ones, never expire.
+func cacheExpiry(exercises []exercise, now time.Time) time.Time {
+	var ttl time.Duration
+	for _, e := range exercises {
+		var exerciseTTL time.Duration
+		switch e.Status {
+		case statusUpstreamError:
+			exerciseTTL = *partialCacheTTL
+		case statusNoMedia:
+			exerciseTTL = *noMediaCacheTTL
+		}
+		if exerciseTTL > 0 && (ttl == 0 || exerciseTTL < ttl) {
+			ttl = exerciseTTL
+		}
+	}
+	if ttl == 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
 }
 
 func getFirestoreName(original string) string {
 	return strings.NewReplacer("/", "_", ":", "_").Replace(original)
 }
 
-func getExercisesFromCache(ctx context.Context, client *firestore.Client, imageURL string) ([]exercise, error) {
-	rawDoc, err := client.Collection(firestoreCollection).Doc(getFirestoreName(imageURL)).Get(ctx)
+// workoutCache stores the result for each workout graphic. load returns nil if
+// nothing is cached under key.
+type workoutCache interface {
+	load(ctx context.Context, key string) (*firestoreDoc, error)
+	save(ctx context.Context, key string, doc *firestoreDoc) error
+}
+
+// firestoreWorkoutCache keeps results in Firestore, one document per graphic.
+type firestoreWorkoutCache struct {
+	client *firestore.Client
+}
+
+func (c *firestoreWorkoutCache) load(ctx context.Context, key string) (*firestoreDoc, error) {
+	rawDoc, err := c.client.Collection(firestoreCollection).Doc(getFirestoreName(key)).Get(ctx)
 	if err != nil && grpc.Code(err) != codes.NotFound {
 		return nil, err
 	}
 	if !rawDoc.Exists() {
-		return nil, docNotFoundError
+		return nil, nil
 	}
 	doc := &firestoreDoc{}
 	if err = rawDoc.DataTo(doc); err != nil {
 		return nil, err
 	}
-	return doc.Exercises, nil
+	return doc, nil
+}
+
+func (c *firestoreWorkoutCache) save(ctx context.Context, key string, doc *firestoreDoc) error {
+	_, err := c.client.Collection(firestoreCollection).Doc(getFirestoreName(key)).Set(ctx, doc)
+	return err
+}
+
+// memoryWorkoutCache keeps results in memory, so they are lost on restart and
+// not shared between instances. It needs no GCP credentials.
+type memoryWorkoutCache struct {
+	mu   sync.Mutex
+	docs map[string]*firestoreDoc
+}
+
+func (c *memoryWorkoutCache) load(ctx context.Context, key string) (*firestoreDoc, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.docs[key], nil
+}
+
+func (c *memoryWorkoutCache) save(ctx context.Context, key string, doc *firestoreDoc) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.docs == nil {
+		c.docs = map[string]*firestoreDoc{}
+	}
+	c.docs[key] = doc
+	return nil
 }
 
-func getExercisesForImage(imageURL string) ([]exercise, error) {
-	text, err := detectText(imageURL)
+// getExercisesFromCache returns the cached result for the graphic of content,
+// along with the URL the graphic was found at. Results are cached under the
+// usual URL of the graphic, so finding them needs no upstream requests.
+func getExercisesFromCache(ctx context.Context, cache workoutCache, content contentRequest) (string, *Workout, []exercise, error) {
+	key, err := content.imageURL()
 	if err != nil {
-		return nil, err
+		return "", nil, nil, err
+	}
+	doc, err := cache.load(ctx, key)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if doc == nil {
+		return "", nil, nil, docNotFoundError
+	}
+	if doc.expired(time.Now()) {
+		log.Printf("Cached partial result for %s has expired", key)
+		return "", nil, nil, docNotFoundError
+	}
+	imageURL := doc.ImageURL
+	if imageURL == "" {
+		// cached before graphics were looked for under other names
+		imageURL = key
+	}
+	return imageURL, doc.Workout, doc.Exercises, nil
+}
+
+func getExercisesForImage(ctx context.Context, ocr *ocrPipeline, catalog *exerciseCatalog, imageURL string) (*Workout, []exercise, error) {
+	annotation, err := ocr.detectText(ctx, imageURL)
+	if err != nil {
+		return nil, nil, err
 	}
+	var lines []ocrLine
+	annotated := getLinesForAnnotation(annotation)
+	for i := range annotated {
+		annotated[i].Text = normaliseLine(annotated[i].Text)
+	}
+	for _, line := range mergeWrappedLines(annotated, catalog, *matchThreshold) {
+		for _, text := range splitCompoundLine(line.Text, catalog, *matchThreshold) {
+			lines = append(lines, ocrLine{Text: text, Box: line.Box, Confidence: line.Confidence})
+		}
+	}
+	var texts []string
+	for _, line := range lines {
+		texts = append(texts, line.Text)
+	}
+	workout := parseWorkout(texts)
 	var exercises []exercise
-	lines := strings.Split(text, "\n")
 	for _, line := range lines {
-		videoName := getVideoName(line)
-		if videoName == "" {
+		// the same lines parseWorkout counted as exercises, so rest
+		// instructions and the like are never looked up
+		if classifyLine(line.Text) != lineExercise {
 			continue
 		}
-		URL := getVideoURL(videoName)
-		embedURL, err := getYoutubeEmbed(URL)
-		if err != nil {
-			return nil, err
+		videoName, aliased := lookupVideoName(line.Text)
+		// correct OCR mistakes by snapping to the closest known exercise,
+		// unless an alias already said which exercise it is
+		var matchScore float64
+		if match, ok := catalog.match(videoName, *matchThreshold); ok && !aliased {
+			videoName, matchScore = match.Slug, match.Score
 		}
-		exercises = append(exercises, exercise{Name: line, EmbedURL: embedURL})
+		parsed, _ := parseExerciseLine(line.Text)
+		exercises = append(exercises, exercise{
+			Name:       parsed.Name,
+			Text:       line.Text,
+			Quantity:   parsed.Quantity,
+			Unit:       parsed.Unit,
+			Side:       parsed.Side,
+			Slug:       videoName,
+			MatchScore: matchScore,
+			Confidence: line.Confidence,
+		})
 	}
-	return exercises, nil
+	if err := resolveExercises(ctx, exercises, catalog, *resolveWorkers); err != nil {
+		nodego.ErrorLogger.Printf("Returning partial results for %s: %v", imageURL, err)
+	}
+	return workout, exercises, nil
 }
 
-func saveExercisesForImageToCache(ctx context.Context, client *firestore.Client, imageURL string, exercises []exercise) error {
-	docName := getFirestoreName(imageURL)
-	doc := &firestoreDoc{Exercises: exercises}
-	if _, err := client.Collection(firestoreCollection).Doc(docName).Set(ctx, doc); err != nil {
+func saveExercisesForImageToCache(ctx context.Context, cache workoutCache, content contentRequest, imageURL string, workout *Workout, exercises []exercise) error {
+	key, err := content.imageURL()
+	if err != nil {
 		return err
 	}
-	return nil
+	doc := &firestoreDoc{Exercises: exercises, Workout: workout, ImageURL: imageURL, Expires: cacheExpiry(exercises, time.Now())}
+	return cache.save(ctx, key, doc)
 }
 
 func parseQueryParam(q url.Values, name string) (string, error) {
@@ -172,65 +406,133 @@ func parseQueryParam(q url.Values, name string) (string, error) {
 	return raw[0], nil
 }
 
-func printVideos(ctx context.Context, client *firestore.Client) func(w http.ResponseWriter, r *http.Request) {
+func printVideos(ctx context.Context, cache workoutCache, ocr *ocrPipeline, catalog *exerciseCatalog, programs *programDirectory, images *imageResolver) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("GET %s", r.RequestURI)
 
 		// Parse query params
 		q := r.URL.Query()
-		workout, err := parseQueryParam(q, "workout")
-		if err != nil {
-			http.Error(w, err.Error(), 500)
-			return
-		}
-		day, err := parseQueryParam(q, "day")
+		content, err := parseContentRequest(q)
 		if err != nil {
 			http.Error(w, err.Error(), 500)
 			return
 		}
 
-		// Construct image URL from query params
-		imageURL, err := getImageURL(workout, day)
-		if err != nil {
-			http.Error(w, err.Error(), 500)
-			return
+		// Check the program has this day before running any OCR
+		var program *programInfo
+		if content.Type == contentProgram {
+			program, err = programs.get(r.Context(), content.Name)
+			if err == nil {
+				err = program.checkDay(content.Day)
+			}
+			if _, ok := err.(*contentNotFoundError); ok {
+				writeImageError(w, err)
+				return
+			}
+			if err != nil {
+				log.Printf("Failed checking program %s, skipping day validation: %v", content.Name, err)
+			}
 		}
-		w.Header().Set("Content-Type", "text/html")
-		fmt.Fprintf(w, `<img src="%s" /><br/>`, imageURL)
 
 		// First try to get exercise from cache
-		exercises, err := getExercisesFromCache(ctx, client, imageURL)
+		imageURL, summary, exercises, err := getExercisesFromCache(ctx, cache, content)
 		if err != nil && err != docNotFoundError {
 			log.Printf("Encountered error when fetching from cache: %v", err)
 		}
-		// Then fall back to calculating exercises from Google Vision API + HTTP GETs
+		// Then fall back to finding the image for the requested content and
+		// calculating exercises from Google Vision API + HTTP GETs
 		if exercises == nil {
 			log.Printf("Cache miss, calculating: %s", r.RequestURI)
-			exercises, err = getExercisesForImage(imageURL)
+			imageURL, err = images.resolve(r.Context(), content)
+			if err != nil {
+				writeImageError(w, err)
+				return
+			}
+			summary, exercises, err = getExercisesForImage(r.Context(), ocr, catalog, imageURL)
 			if err != nil {
-				http.Error(w, err.Error(), 500)
+				writeImageError(w, err)
 				return
 			}
 			// Put in cache for next time
-			err := saveExercisesForImageToCache(ctx, client, imageURL, exercises)
+			err := saveExercisesForImageToCache(ctx, cache, content, imageURL, summary, exercises)
 			if err != nil {
 				log.Printf("Failed saving exercises for %s to cache: %v", imageURL, err)
 			}
 		}
-		for _, exercise := range exercises {
-			if exercise.EmbedURL != "" {
-				fmt.Fprintf(w, `
-                   <h2>%s</h2>
-                   <p>
-                       <iframe width="845" height="480" src="//www.youtube.com/embed/%s?rel=0&showinfo=0" frameborder="0" allowfullscreen></iframe>
-                   </p>`, exercise.Name, exercise.EmbedURL)
-			} else {
-				fmt.Fprintf(w, `
-                   <h2>%s</h2>
-                   <p>Video not found</p>
-               `, exercise.Name)
+		if q.Get("format") == "json" {
+			renderJSON(w, imageURL, program, summary, exercises)
+			return
+		}
+		renderHTML(w, imageURL, program, summary, exercises)
+	}
+}
+
+// writeImageError responds to a failure to OCR the workout image, telling apart
+// a failure to download it from a failure to read it.
+func writeImageError(w http.ResponseWriter, err error) {
+	if notFoundErr, ok := err.(*contentNotFoundError); ok {
+		http.Error(w, notFoundErr.Error(), http.StatusNotFound)
+		return
+	}
+	if fetchErr, ok := err.(*imageFetchError); ok {
+		log.Printf("Failed fetching workout image: %v", fetchErr)
+		if fetchErr.StatusCode == http.StatusNotFound {
+			http.Error(w, fetchErr.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, fetchErr.Error(), http.StatusBadGateway)
+		}
+		return
+	}
+	http.Error(w, err.Error(), 500)
+}
+
+// debugUpstream responds with the counters of requests made to the upstream.
+func debugUpstream(t *upstreamTransport) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(t.snapshot()); err != nil {
+			log.Printf("Failed writing upstream metrics: %v", err)
+		}
+	}
+}
+
+// debugPreprocess responds with the workout image exactly as it is sent to the
+// text detector. A preprocess query param overrides the configured steps, which
+// makes it easy to try out new settings.
+func debugPreprocess(ocr *ocrPipeline, images *imageResolver) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("GET %s", r.RequestURI)
+
+		q := r.URL.Query()
+		content, err := parseContentRequest(q)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		imageURL, err := images.resolve(r.Context(), content)
+		if err != nil {
+			writeImageError(w, err)
+			return
+		}
+		opts := ocr.preprocess
+		if spec, ok := q["preprocess"]; ok {
+			if opts, err = parsePreprocessOptions(spec[0]); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
 			}
 		}
+
+		image, err := ocr.prepareImage(r.Context(), imageURL, opts)
+		if err != nil {
+			writeImageError(w, err)
+			return
+		}
+		if opts.enabled() {
+			w.Header().Set("Content-Type", "image/png")
+		} else {
+			w.Header().Set("Content-Type", http.DetectContentType(image.Content))
+		}
+		w.Write(image.Content)
 	}
 }
 
@@ -240,16 +542,102 @@ func init() {
 
 func main() {
 	flag.Parse()
+	outbound = newUpstreamTransport(http.DefaultTransport, upstreamOptions{
+		Timeout: *upstreamTimeout,
+		Retries: *upstreamRetries,
+		Backoff: defaultUpstreamOptions().Backoff,
+		Rate:    *upstreamRate,
+	})
 
-	// setup Firestore connection
+	// setup Firestore connection, only if something is stored there
 	ctx := context.Background()
-	client, err := firestore.NewClient(ctx, "darebee-208813")
+	var client *firestore.Client
+	firestoreClient := func() *firestore.Client {
+		if client == nil {
+			var err error
+			if client, err = firestore.NewClient(ctx, "darebee-208813"); err != nil {
+				log.Fatalf("Failed to create client: %v", err)
+			}
+		}
+		return client
+	}
+	defer func() {
+		if client != nil {
+			client.Close()
+		}
+	}()
+
+	// setup exercise catalog, or refresh it from the exercise library and exit
+	var store catalogStore
+	if *catalogFile != "" {
+		store = &fileCatalogStore{path: *catalogFile}
+	} else {
+		store = &firestoreCatalogStore{client: firestoreClient()}
+	}
+	if *crawl {
+		crawler := &libraryCrawler{client: outbound.client(), maxPages: *crawlMaxPages}
+		indexURL := *libraryURL
+		if indexURL == "" {
+			indexURL = upstream("/exercises.html")
+		}
+		entries, err := crawler.crawl(ctx, indexURL)
+		if err != nil {
+			log.Fatalf("Failed crawling exercise library: %v", err)
+		}
+		if err := store.save(ctx, entries); err != nil {
+			log.Fatalf("Failed saving exercise catalog: %v", err)
+		}
+		log.Printf("Saved %d exercises to the catalog", len(entries))
+		return
+	}
+	catalog := loadCatalog(ctx, store)
+
+	// setup exercise aliases
+	if *aliasFile != "" {
+		aliases.store = &fileAliasStore{path: *aliasFile}
+	} else {
+		aliases.store = &firestoreAliasStore{client: firestoreClient()}
+	}
+	if err := aliases.reload(ctx); err != nil {
+		log.Printf("Failed loading aliases, using defaults: %v", err)
+	}
+	if *aliasReloadInterval > 0 {
+		go aliases.reloadEvery(ctx, *aliasReloadInterval)
+	}
+
+	// setup the workout cache and the exercise cache shared across workouts
+	var cache workoutCache
+	switch *cacheKind {
+	case "firestore":
+		cache = &firestoreWorkoutCache{client: firestoreClient()}
+		slugCache.store = &firestoreExerciseCacheStore{client: firestoreClient()}
+	case "memory":
+		cache = &memoryWorkoutCache{}
+		slugCache.store = &memoryExerciseCacheStore{}
+	default:
+		log.Fatalf("Unknown cache %q, expected firestore or memory", *cacheKind)
+	}
+
+	// setup OCR backend
+	detector, err := newTextDetector(ctx, *detectorKind, *fixtureDir)
+	if err != nil {
+		log.Fatalf("Failed to create text detector: %v", err)
+	}
+	defer detector.Close()
+	preprocess, err := parsePreprocessOptions(*preprocessSpec)
 	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
+		log.Fatalf("Invalid preprocessing options: %v", err)
 	}
-	defer client.Close()
+	ocr := &ocrPipeline{fetcher: newImageFetcher(outbound.client(), 32), preprocess: preprocess, detector: detector}
+
+	programs := newProgramDirectory(outbound.client(), upstream(""))
+	images := newImageResolver(outbound.client())
 
-	http.HandleFunc(nodego.HTTPTrigger, printVideos(ctx, client))
+	http.HandleFunc(nodego.HTTPTrigger, printVideos(ctx, cache, ocr, catalog, programs, images))
+	http.HandleFunc(nodego.HTTPTrigger+"/debug/preprocess", debugPreprocess(ocr, images))
+	http.HandleFunc(nodego.HTTPTrigger+"/debug/upstream", debugUpstream(outbound))
+	http.HandleFunc(nodego.HTTPTrigger+"/admin/aliases", adminAliases(aliases, catalog, *adminToken))
+	http.HandleFunc(nodego.HTTPTrigger+"/admin/aliases/reload", adminAliases(aliases, catalog, *adminToken))
 
 	nodego.TakeOver()
 }
@@ -1,8 +1,19 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"golang.org/x/net/context"
 	"gotest.tools/assert"
 )
 
@@ -36,38 +47,270 @@ func TestGetVideoName(t *testing.T) {
 		assert.Equal(t, "bridges-exercise", getVideoName("10 bridges"))
 		assert.Equal(t, "skiers-exercise", getVideoName("20 skiers"))
 	})
+	t.Run("units and sides are not part of the name", func(t *testing.T) {
+		assert.Equal(t, "jumping-jacks", getVideoName("20 seconds jumping jacks"))
+		assert.Equal(t, "plank-exercise", getVideoName("30s plank"))
+		assert.Equal(t, "side-leg-raises", getVideoName("10 side leg raises each side"))
+	})
 	t.Run("exceptions", func(t *testing.T) {
 		assert.Equal(t, "arm-leg-raises", getVideoName("10 alt arm / leg raises"))
 		assert.Equal(t, "forward-lunges", getVideoName("20 lunges"))
 	})
 }
 
-func TestGetImageURL(t *testing.T) {
+func TestProgramImageURL(t *testing.T) {
 	t.Run("basic case", func(t *testing.T) {
-		imageURL, err := getImageURL("foundation", "23")
+		imageURL, err := contentRequest{Type: contentProgram, Name: "foundation", Day: "23"}.imageURL()
 		assert.NilError(t, err)
 		assert.Equal(t, "https://darebee.com/images/programs/foundation/web/day23.jpg", imageURL)
 	})
 	t.Run("pad zeroes", func(t *testing.T) {
-		imageURL, err := getImageURL("foundation", "3")
+		imageURL, err := contentRequest{Type: contentProgram, Name: "foundation", Day: "3"}.imageURL()
 		assert.NilError(t, err)
 		assert.Equal(t, "https://darebee.com/images/programs/foundation/web/day03.jpg", imageURL)
 	})
 	t.Run("case insensitive", func(t *testing.T) {
-		imageURL, err := getImageURL("Foundation", "3")
+		imageURL, err := contentRequest{Type: contentProgram, Name: "Foundation", Day: "3"}.imageURL()
 		assert.NilError(t, err)
 		assert.Equal(t, "https://darebee.com/images/programs/foundation/web/day03.jpg", imageURL)
 	})
 	t.Run("number parsing", func(t *testing.T) {
-		imageURL, err := getImageURL("foundation", "004")
+		imageURL, err := contentRequest{Type: contentProgram, Name: "foundation", Day: "004"}.imageURL()
 		assert.NilError(t, err)
 		assert.Equal(t, "https://darebee.com/images/programs/foundation/web/day04.jpg", imageURL)
 	})
 }
 
-// TODO: refactor this function so the test doesn't make actual HTTP request
+// useUpstream points the service at a mirror of the Darebee site until the
+// returned function is called.
+func useUpstream(baseURL string) func() {
+	original := *upstreamURL
+	*upstreamURL = baseURL
+	return func() { *upstreamURL = original }
+}
+
 func TestGetYoutubeEmbed(t *testing.T) {
-	embedURL, err := getYoutubeEmbed("https://darebee.com/exercises/burpees-with-push-up.html")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/exercises/burpees-with-push-up.html":
+		case "/exercises/plank-exercise.html":
+			fmt.Fprint(w, `<h1>Plank</h1>`)
+			return
+		case "/exercises/broken-exercise.html":
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `<iframe width="560" height="315" src="https://www.youtube.com/embed/ZQzikdjmkKg?rel=0&amp;showinfo=0" frameborder="0"></iframe>`)
+	}))
+	defer server.Close()
+	defer useUpstream(server.URL)()
+
+	t.Run("exercise with video", func(t *testing.T) {
+		embedURL, err := getYoutubeEmbed(context.Background(), getVideoURL("burpees-with-push-up"))
+		assert.NilError(t, err)
+		assert.Equal(t, "ZQzikdjmkKg", embedURL)
+	})
+	t.Run("missing page", func(t *testing.T) {
+		_, err := getYoutubeEmbed(context.Background(), getVideoURL("no-such-exercise"))
+		_, ok := err.(*pageNotFoundError)
+		assert.Assert(t, ok, "got %v", err)
+	})
+	t.Run("page without video", func(t *testing.T) {
+		_, err := getYoutubeEmbed(context.Background(), getVideoURL("plank-exercise"))
+		_, ok := err.(*noMediaError)
+		assert.Assert(t, ok, "got %v", err)
+	})
+	t.Run("upstream failure", func(t *testing.T) {
+		_, err := getYoutubeEmbed(context.Background(), getVideoURL("broken-exercise"))
+		unavailable, ok := err.(*upstreamUnavailableError)
+		assert.Assert(t, ok, "got %v", err)
+		assert.Equal(t, http.StatusInternalServerError, unavailable.StatusCode)
+	})
+}
+
+func TestUpstream(t *testing.T) {
+	defer useUpstream("http://localhost:8000/")()
+	assert.Equal(t, "http://localhost:8000/exercises/knee-strikes.html", getVideoURL("knee-strikes"))
+	imageURL, err := contentRequest{Type: contentProgram, Name: "foundation", Day: "3"}.imageURL()
 	assert.NilError(t, err)
-	assert.Equal(t, "ZQzikdjmkKg", embedURL)
+	assert.Equal(t, "http://localhost:8000/images/programs/foundation/web/day03.jpg", imageURL)
+	imageURL, err = contentRequest{Type: contentWorkout, Name: "fighter-workout"}.imageURL()
+	assert.NilError(t, err)
+	assert.Equal(t, "http://localhost:8000/images/workouts/fighter-workout.jpg", imageURL)
+}
+
+func TestCacheExpiry(t *testing.T) {
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	t.Run("complete results never expire", func(t *testing.T) {
+		expires := cacheExpiry([]exercise{{Status: statusResolved}, {Status: statusNotFound}}, now)
+		assert.Assert(t, expires.IsZero())
+		doc := &firestoreDoc{Expires: expires}
+		assert.Assert(t, !doc.expired(now.Add(365*24*time.Hour)))
+	})
+	t.Run("pages without a video expire", func(t *testing.T) {
+		expires := cacheExpiry([]exercise{{Status: statusResolved}, {Status: statusNoMedia}}, now)
+		assert.Equal(t, now.Add(*noMediaCacheTTL), expires)
+	})
+	t.Run("upstream failures expire first", func(t *testing.T) {
+		expires := cacheExpiry([]exercise{{Status: statusNoMedia}, {Status: statusUpstreamError}}, now)
+		assert.Equal(t, now.Add(*partialCacheTTL), expires)
+	})
+	t.Run("partial results expire", func(t *testing.T) {
+		expires := cacheExpiry([]exercise{{Status: statusResolved}, {Status: statusUpstreamError}}, now)
+		assert.Equal(t, now.Add(*partialCacheTTL), expires)
+		doc := &firestoreDoc{Expires: expires}
+		assert.Assert(t, !doc.expired(now))
+		assert.Assert(t, doc.expired(expires.Add(time.Second)))
+	})
+}
+
+func TestPrintVideos(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	defer useUpstream(server.URL)()
+
+	t.Run("cache hit makes no upstream request", func(t *testing.T) {
+		cache := &memoryWorkoutCache{docs: map[string]*firestoreDoc{
+			server.URL + "/images/workouts/fighter-workout.jpg": {
+				Exercises: []exercise{{Name: "knee strikes", Slug: "knee-strikes", EmbedURL: "abc123", Status: statusResolved}},
+				ImageURL:  server.URL + "/images/workouts/fighter-workout.png",
+			},
+		}}
+		handler := printVideos(context.Background(), cache, nil, newExerciseCatalog(nil), nil, newImageResolver(server.Client()))
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", "/execute?type=workout&workout=fighter-workout&format=json", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 0, requests)
+		assert.Assert(t, strings.Contains(w.Body.String(), "fighter-workout.png"))
+		assert.Assert(t, strings.Contains(w.Body.String(), "abc123"))
+	})
+	t.Run("cache miss looks for the image", func(t *testing.T) {
+		handler := printVideos(context.Background(), &memoryWorkoutCache{}, nil, newExerciseCatalog(nil), nil, newImageResolver(server.Client()))
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", "/execute?type=workout&workout=fighter-workout", nil))
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+		assert.Assert(t, requests > 0)
+	})
+}
+
+// newTestPipeline runs OCR against the recordings in fixtureDir, fetching
+// images with client.
+func newTestPipeline(t *testing.T, client *http.Client, fixtureDir string) *ocrPipeline {
+	detector, err := newFixtureDetector(fixtureDir)
+	assert.NilError(t, err)
+	return &ocrPipeline{fetcher: newImageFetcher(client, 32), detector: detector}
+}
+
+// useAliases looks up aliases in table until the returned function is called.
+func useAliases(table *aliasTable) func() {
+	original := aliases
+	aliases = table
+	return func() { aliases = original }
+}
+
+func TestGetExercisesForImage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/images/") {
+			w.Header().Set("Content-Type", "image/jpeg")
+			fmt.Fprint(w, "jpeg")
+			return
+		}
+		slug := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/exercises/"), ".html")
+		fmt.Fprintf(w, `<iframe src="https://www.youtube.com/embed/%s?rel=0"></iframe>`, slug)
+	}))
+	defer server.Close()
+	defer useUpstream(server.URL)()
+	defer useOutbound(newUpstreamTransport(server.Client().Transport, upstreamOptions{}))()
+	dir, err := ioutil.TempDir("", "ocr")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	ocr := newTestPipeline(t, server.Client(), dir)
+	catalog := newExerciseCatalog(seedCatalogEntries())
+
+	t.Run("aliases aren't snapped to the catalog", func(t *testing.T) {
+		defer useAliases(newAliasTable(map[string]string{"side-chop": "side-chops"}))()
+		imageURL := upstream("/images/workouts/chops.jpg")
+		recording := filepath.Join(dir, getFirestoreName(defaultUpstreamURL+"/images/workouts/chops.jpg")+".txt")
+		assert.NilError(t, ioutil.WriteFile(recording, []byte("Chops\n10 side chop"), 0644))
+		_, exercises, err := getExercisesForImage(context.Background(), ocr, catalog, imageURL)
+		assert.NilError(t, err)
+		assert.Equal(t, 1, len(exercises))
+		assert.Equal(t, "side-chops", exercises[0].Slug)
+		assert.Equal(t, "side-chops", exercises[0].EmbedURL)
+	})
+	t.Run("rest instructions aren't exercises", func(t *testing.T) {
+		imageURL := upstream("/images/workouts/rest.jpg")
+		recording := filepath.Join(dir, getFirestoreName(defaultUpstreamURL+"/images/workouts/rest.jpg")+".txt")
+		assert.NilError(t, ioutil.WriteFile(recording, []byte("Rest Day\n20 squats\n30 seconds rest\n10 burpees\n1 minute rest"), 0644))
+		workout, exercises, err := getExercisesForImage(context.Background(), ocr, catalog, imageURL)
+		assert.NilError(t, err)
+		assert.Equal(t, 2, len(workout.Rest))
+		assert.Equal(t, 2, len(exercises))
+		assert.Equal(t, "squats-exercise", exercises[0].Slug)
+		assert.Equal(t, "burpees-exercise", exercises[1].Slug)
+	})
+}
+
+func TestPrintVideosWithFixtures(t *testing.T) {
+	// requests counts fetches of images and exercise pages, but not of the
+	// program page, which is still checked on a cache hit.
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/images/"):
+			mu.Lock()
+			requests++
+			mu.Unlock()
+			w.Header().Set("Content-Type", "image/jpeg")
+			fmt.Fprint(w, "jpeg")
+		case strings.HasPrefix(r.URL.Path, "/exercises/"):
+			mu.Lock()
+			requests++
+			mu.Unlock()
+			slug := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/exercises/"), ".html")
+			fmt.Fprintf(w, `<iframe src="https://www.youtube.com/embed/%s?rel=0"></iframe>`, slug)
+		default:
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	defer useUpstream(server.URL)()
+	defer useOutbound(newUpstreamTransport(server.Client().Transport, upstreamOptions{}))()
+	handler := printVideos(context.Background(), &memoryWorkoutCache{}, newTestPipeline(t, server.Client(), "testdata/ocr"),
+		newExerciseCatalog(seedCatalogEntries()), newProgramDirectory(server.Client(), server.URL), newImageResolver(server.Client()))
+
+	t.Run("reads the workout from the OCR fixture", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", "/execute?workout=foundation&day=3&format=json", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		var result struct {
+			Exercises []exercise `json:"exercises"`
+		}
+		assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &result))
+		var embeds []string
+		for _, e := range result.Exercises {
+			embeds = append(embeds, e.EmbedURL)
+		}
+		assert.DeepEqual(t, []string{"knee-strikes", "low-front-kicks", "overhead-punches", "jab-jab-cross"}, embeds)
+	})
+	t.Run("serves the workout from the memory cache", func(t *testing.T) {
+		mu.Lock()
+		before := requests
+		mu.Unlock()
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", "/execute?workout=foundation&day=3&format=json", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Assert(t, before > 0)
+		assert.Equal(t, before, requests)
+	})
 }
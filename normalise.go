@@ -0,0 +1,55 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// countRegexp matches a leading count that OCR may have misread, e.g. "2O",
+	// "l0" or "1S", optionally followed by a unit suffix as in "3Os".
+	countRegexp      = regexp.MustCompile(`^([0-9OoIl|S]+)([sx]?)(\s|$)`)
+	levelLabelRegexp = regexp.MustCompile(`(?i)^leve[l1i|]\s*([ivxl1|]+)(\s|$)`)
+	dayLabelRegexp   = regexp.MustCompile(`(?i)^(day)\s+([0-9OoIl|S]+)(\s|$)`)
+	// timedExerciseRegexp matches exercises that are held for a time rather
+	// than counted, where "30S" is more likely 30 seconds than 305.
+	timedExerciseRegexp = regexp.MustCompile(`(?i)\b(plank|wall sit|hold|hang|stretch)`)
+	digitFixer          = strings.NewReplacer("O", "0", "o", "0", "I", "1", "l", "1", "|", "1", "S", "5")
+)
+
+// normaliseLine fixes the characters OCR most often confuses in the parts of a
+// line that are known to be numbers or keywords, so "2O squats" reads as
+// "20 squats", "l0 burpees" as "10 burpees" and "Levell 3 sets" as
+// "Level I 3 sets". Exercise names themselves are left to catalog matching.
+func normaliseLine(line string) string {
+	line = strings.TrimSpace(line)
+	if matches := countRegexp.FindStringSubmatch(line); matches != nil {
+		count, unit := matches[1], matches[2]
+		rest := line[len(count)+len(unit):]
+		if unit == "" && len(count) > 1 && strings.HasSuffix(count, "S") && timedExerciseRegexp.MatchString(rest) {
+			count, unit = count[:len(count)-1], "S"
+		}
+		if fixed, ok := fixDigits(count); ok {
+			line = fixed + unit + rest
+		}
+	}
+	if matches := levelLabelRegexp.FindStringSubmatch(line); matches != nil {
+		line = "Level " + romanLevel(strings.Replace(matches[1], "|", "l", -1)) + line[len(matches[0])-len(matches[2]):]
+	}
+	if matches := dayLabelRegexp.FindStringSubmatchIndex(line); matches != nil {
+		if day, ok := fixDigits(line[matches[4]:matches[5]]); ok {
+			line = line[:matches[4]] + day + line[matches[5]:]
+		}
+	}
+	return line
+}
+
+// fixDigits maps letters commonly misread for digits back to digits. ok is
+// false unless the text has at least one real digit, so words like "So" or
+// "I" aren't mistaken for counts.
+func fixDigits(text string) (fixed string, ok bool) {
+	if !strings.ContainsAny(text, "0123456789") {
+		return text, false
+	}
+	return digitFixer.Replace(text), true
+}
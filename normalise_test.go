@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestNormaliseLine(t *testing.T) {
+	t.Run("misread counts", func(t *testing.T) {
+		assert.Equal(t, "20 squats", normaliseLine("2O squats"))
+		assert.Equal(t, "10 burpees", normaliseLine("l0 burpees"))
+		assert.Equal(t, "10 burpees", normaliseLine("I0 burpees"))
+		assert.Equal(t, "50 squats", normaliseLine("S0 squats"))
+		assert.Equal(t, "15 squats", normaliseLine("1S squats"))
+		assert.Equal(t, "25 burpees", normaliseLine("2S burpees"))
+	})
+	t.Run("seconds written against the count", func(t *testing.T) {
+		assert.Equal(t, "30S plank", normaliseLine("30S plank"))
+		assert.Equal(t, "20S wall sit", normaliseLine("2OS wall sit"))
+		assert.Equal(t, "30s plank", normaliseLine("3Os plank"))
+	})
+	t.Run("recognised as exercises", func(t *testing.T) {
+		assert.Equal(t, "squats-exercise", getVideoName(normaliseLine("2O squats")))
+		assert.Equal(t, "burpees-exercise", getVideoName(normaliseLine("l0 burpees")))
+	})
+	t.Run("level labels", func(t *testing.T) {
+		assert.Equal(t, "Level I 3 sets", normaliseLine("Levell 3 sets"))
+		assert.Equal(t, "Level II 5 sets", normaliseLine("Level II 5 sets"))
+		assert.Equal(t, "Level III 7 sets", normaliseLine("LeveI lII 7 sets"))
+	})
+	t.Run("day labels", func(t *testing.T) {
+		assert.Equal(t, "Day 13 Fighter", normaliseLine("Day l3 Fighter"))
+	})
+	t.Run("words are left alone", func(t *testing.T) {
+		assert.Equal(t, "Foundation", normaliseLine("Foundation"))
+		assert.Equal(t, "o darebee.com", normaliseLine("o darebee.com"))
+		assert.Equal(t, "So strong", normaliseLine("So strong"))
+		assert.Equal(t, "20 knee strikes", normaliseLine("20 knee strikes"))
+	})
+}
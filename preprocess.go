@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/jpeg"
+	"image/png"
+	"strconv"
+	"strings"
+)
+
+// preprocessOptions configures how a workout image is cleaned up before OCR.
+// The zero value leaves the image untouched.
+type preprocessOptions struct {
+	// Crop is the region of the image to keep, as fractions of its width and
+	// height, so the illustration strip can be cut away.
+	Crop *cropRect
+	// Grayscale drops colour, which removes most of the coloured backgrounds.
+	Grayscale bool
+	// Threshold turns the image black and white: pixels darker than it become
+	// black and everything else white. Zero disables thresholding.
+	Threshold uint8
+	// Scale upscales the image by an integer factor so small text has more
+	// pixels to work with.
+	Scale int
+}
+
+type cropRect struct {
+	X0, Y0, X1, Y1 float64
+}
+
+func (o preprocessOptions) enabled() bool {
+	return o.Crop != nil || o.Grayscale || o.Threshold > 0 || o.Scale > 1
+}
+
+// parsePreprocessOptions parses a comma separated spec such as
+// "crop=0:0.3:1:1,grayscale,threshold=160,scale=2". An empty spec disables
+// preprocessing.
+func parsePreprocessOptions(spec string) (preprocessOptions, error) {
+	var opts preprocessOptions
+	for _, step := range strings.Split(spec, ",") {
+		step = strings.TrimSpace(step)
+		if step == "" {
+			continue
+		}
+		name, value := step, ""
+		if i := strings.Index(step, "="); i >= 0 {
+			name, value = step[:i], step[i+1:]
+		}
+		switch name {
+		case "grayscale":
+			opts.Grayscale = true
+		case "threshold":
+			threshold, err := strconv.ParseUint(value, 10, 8)
+			if err != nil || threshold == 0 {
+				return opts, fmt.Errorf("invalid threshold %q: expected 1-255", value)
+			}
+			opts.Threshold = uint8(threshold)
+		case "scale":
+			scale, err := strconv.Atoi(value)
+			if err != nil || scale < 1 || scale > 4 {
+				return opts, fmt.Errorf("invalid scale %q: expected 1-4", value)
+			}
+			opts.Scale = scale
+		case "crop":
+			crop, err := parseCropRect(value)
+			if err != nil {
+				return opts, err
+			}
+			opts.Crop = crop
+		default:
+			return opts, fmt.Errorf("unknown preprocessing step %q", name)
+		}
+	}
+	return opts, nil
+}
+
+func parseCropRect(value string) (*cropRect, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("invalid crop %q: expected x0:y0:x1:y1", value)
+	}
+	var bounds [4]float64
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(part, 64)
+		if err != nil || f < 0 || f > 1 {
+			return nil, fmt.Errorf("invalid crop %q: bounds must be fractions between 0 and 1", value)
+		}
+		bounds[i] = f
+	}
+	if bounds[0] >= bounds[2] || bounds[1] >= bounds[3] {
+		return nil, fmt.Errorf("invalid crop %q: region is empty", value)
+	}
+	return &cropRect{X0: bounds[0], Y0: bounds[1], X1: bounds[2], Y1: bounds[3]}, nil
+}
+
+// preprocessImage applies opts to a downloaded workout image, returning a PNG
+// encoded copy. The original is returned untouched if nothing is enabled.
+func preprocessImage(original *workoutImage, opts preprocessOptions) (*workoutImage, error) {
+	if !opts.enabled() {
+		return original, nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(original.Content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image %s: %v", original.URL, err)
+	}
+	if opts.Crop != nil {
+		img = cropImage(img, *opts.Crop)
+	}
+	if opts.Grayscale || opts.Threshold > 0 {
+		img = grayscaleImage(img)
+	}
+	if opts.Threshold > 0 {
+		thresholdImage(img.(*image.Gray), opts.Threshold)
+	}
+	if opts.Scale > 1 {
+		img = upscaleImage(img, opts.Scale)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return &workoutImage{URL: original.URL, Content: buf.Bytes()}, nil
+}
+
+func cropImage(img image.Image, crop cropRect) image.Image {
+	b := img.Bounds()
+	rect := image.Rect(
+		b.Min.X+int(crop.X0*float64(b.Dx())),
+		b.Min.Y+int(crop.Y0*float64(b.Dy())),
+		b.Min.X+int(crop.X1*float64(b.Dx())),
+		b.Min.Y+int(crop.Y1*float64(b.Dy())),
+	)
+	cropped := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(cropped, cropped.Bounds(), img, rect.Min, draw.Src)
+	return cropped
+}
+
+func grayscaleImage(img image.Image) *image.Gray {
+	b := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(gray, gray.Bounds(), img, b.Min, draw.Src)
+	return gray
+}
+
+func thresholdImage(img *image.Gray, threshold uint8) {
+	for i, y := range img.Pix {
+		if y < threshold {
+			img.Pix[i] = 0
+		} else {
+			img.Pix[i] = 255
+		}
+	}
+}
+
+// upscaleImage enlarges img by an integer factor using nearest neighbour
+// sampling, which keeps thresholded edges sharp.
+func upscaleImage(img image.Image, factor int) image.Image {
+	b := img.Bounds()
+	var scaled draw.Image
+	if _, ok := img.(*image.Gray); ok {
+		scaled = image.NewGray(image.Rect(0, 0, b.Dx()*factor, b.Dy()*factor))
+	} else {
+		scaled = image.NewRGBA(image.Rect(0, 0, b.Dx()*factor, b.Dy()*factor))
+	}
+	for y := 0; y < b.Dy()*factor; y++ {
+		for x := 0; x < b.Dx()*factor; x++ {
+			scaled.Set(x, y, img.At(b.Min.X+x/factor, b.Min.Y+y/factor))
+		}
+	}
+	return scaled
+}
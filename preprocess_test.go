@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestParsePreprocessOptions(t *testing.T) {
+	t.Run("empty spec disables preprocessing", func(t *testing.T) {
+		opts, err := parsePreprocessOptions("")
+		assert.NilError(t, err)
+		assert.Assert(t, !opts.enabled())
+	})
+	t.Run("all steps", func(t *testing.T) {
+		opts, err := parsePreprocessOptions("crop=0:0.25:1:1, grayscale, threshold=160, scale=2")
+		assert.NilError(t, err)
+		assert.DeepEqual(t, preprocessOptions{
+			Crop:      &cropRect{X0: 0, Y0: 0.25, X1: 1, Y1: 1},
+			Grayscale: true,
+			Threshold: 160,
+			Scale:     2,
+		}, opts)
+	})
+	t.Run("invalid steps", func(t *testing.T) {
+		_, err := parsePreprocessOptions("sharpen")
+		assert.Error(t, err, `unknown preprocessing step "sharpen"`)
+		_, err = parsePreprocessOptions("threshold=300")
+		assert.Error(t, err, `invalid threshold "300": expected 1-255`)
+		_, err = parsePreprocessOptions("scale=0")
+		assert.Error(t, err, `invalid scale "0": expected 1-4`)
+		_, err = parsePreprocessOptions("crop=0:0:1")
+		assert.Error(t, err, `invalid crop "0:0:1": expected x0:y0:x1:y1`)
+		_, err = parsePreprocessOptions("crop=0.5:0:0.5:1")
+		assert.Error(t, err, `invalid crop "0.5:0:0.5:1": region is empty`)
+	})
+}
+
+// testImage is a 4x2 image with a dark red left half and a light yellow right half.
+func testImage(t *testing.T) *workoutImage {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			if x < 2 {
+				img.Set(x, y, color.RGBA{R: 120, A: 255})
+			} else {
+				img.Set(x, y, color.RGBA{R: 250, G: 240, B: 180, A: 255})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	assert.NilError(t, png.Encode(&buf, img))
+	return &workoutImage{URL: "https://darebee.com/images/programs/foundation/web/day03.jpg", Content: buf.Bytes()}
+}
+
+func decodeTestImage(t *testing.T, processed *workoutImage) image.Image {
+	img, err := png.Decode(bytes.NewReader(processed.Content))
+	assert.NilError(t, err)
+	return img
+}
+
+func TestPreprocessImage(t *testing.T) {
+	t.Run("disabled returns original", func(t *testing.T) {
+		original := testImage(t)
+		processed, err := preprocessImage(original, preprocessOptions{})
+		assert.NilError(t, err)
+		assert.Equal(t, original, processed)
+	})
+	t.Run("crop", func(t *testing.T) {
+		processed, err := preprocessImage(testImage(t), preprocessOptions{Crop: &cropRect{X0: 0.5, Y0: 0, X1: 1, Y1: 1}})
+		assert.NilError(t, err)
+		img := decodeTestImage(t, processed)
+		assert.Equal(t, image.Rect(0, 0, 2, 2), img.Bounds())
+		r, _, _, _ := img.At(0, 0).RGBA()
+		assert.Equal(t, uint32(250*0x101), r)
+	})
+	t.Run("threshold", func(t *testing.T) {
+		processed, err := preprocessImage(testImage(t), preprocessOptions{Threshold: 128})
+		assert.NilError(t, err)
+		img := decodeTestImage(t, processed)
+		assert.Equal(t, color.Gray{Y: 0}, color.GrayModel.Convert(img.At(0, 0)))
+		assert.Equal(t, color.Gray{Y: 255}, color.GrayModel.Convert(img.At(3, 1)))
+	})
+	t.Run("scale", func(t *testing.T) {
+		processed, err := preprocessImage(testImage(t), preprocessOptions{Grayscale: true, Scale: 3})
+		assert.NilError(t, err)
+		img := decodeTestImage(t, processed)
+		assert.Equal(t, image.Rect(0, 0, 12, 6), img.Bounds())
+		assert.Equal(t, img.At(0, 0), img.At(5, 5))
+	})
+	t.Run("undecodable image", func(t *testing.T) {
+		_, err := preprocessImage(&workoutImage{URL: "https://darebee.com/broken.jpg", Content: []byte("<html>")}, preprocessOptions{Grayscale: true})
+		assert.ErrorContains(t, err, "failed to decode image https://darebee.com/broken.jpg")
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// programInfo describes a Darebee program, as found on its program page.
+type programInfo struct {
+	Slug  string `json:"slug"`
+	Title string `json:"title"`
+	Days  int    `json:"days"`
+	// DaysEstimated is set if Days was read from the program's description
+	// rather than counted from its day graphics, so can't be relied on.
+	DaysEstimated bool   `json:"daysEstimated,omitempty"`
+	Description   string `json:"description,omitempty"`
+	Difficulty    string `json:"difficulty,omitempty"`
+}
+
+var (
+	pageTitleRegexp   = regexp.MustCompile(`(?is)<h1[^>]*>(.*?)</h1>`)
+	descriptionRegexp = regexp.MustCompile(`(?is)<meta\s+name="description"\s+content="([^"]*)"`)
+	difficultyRegexp  = regexp.MustCompile(`(?is)difficulty(?:\s+level)?\s*:?\s*(?:<[^>]*>\s*)*([a-z0-9]+)`)
+	dayImageRegexp    = regexp.MustCompile(`/web/day(\d+)\.jpg`)
+	dayCountRegexp    = regexp.MustCompile(`(?i)\b(\d+)[\s-]+days?\b`)
+)
+
+// contentNotFoundError is returned for programs, or days of a program, that
+// don't exist.
+type contentNotFoundError struct {
+	message string
+}
+
+func (e *contentNotFoundError) Error() string {
+	return e.message
+}
+
+// checkDay returns a contentNotFoundError if day is outside the program. Days
+// can't be checked if the number of days is unknown or only estimated.
+func (p *programInfo) checkDay(day string) error {
+	dayNum, err := strconv.Atoi(day)
+	if err != nil {
+		return err
+	}
+	if p.Days > 0 && !p.DaysEstimated && (dayNum < 1 || dayNum > p.Days) {
+		return &contentNotFoundError{fmt.Sprintf("%s has no day %d, only days 1 to %d", p.Title, dayNum, p.Days)}
+	}
+	return nil
+}
+
+// programDirectory looks up program metadata from the program pages, keeping
+// what it finds for the lifetime of the service.
+type programDirectory struct {
+	client  *http.Client
+	baseURL string
+
+	mu       sync.Mutex
+	programs map[string]*programInfo
+}
+
+func newProgramDirectory(client *http.Client, baseURL string) *programDirectory {
+	return &programDirectory{client: client, baseURL: baseURL, programs: map[string]*programInfo{}}
+}
+
+// get returns the metadata for the program with the given slug, or a
+// contentNotFoundError if there is no such program.
+func (d *programDirectory) get(ctx context.Context, slug string) (*programInfo, error) {
+	slug = strings.ToLower(slug)
+	d.mu.Lock()
+	program, ok := d.programs[slug]
+	d.mu.Unlock()
+	if ok {
+		return program, nil
+	}
+	page, err := fetchPage(ctx, d.client, fmt.Sprintf("%s/programs/%s.html", d.baseURL, slug))
+	if _, ok := err.(*pageNotFoundError); ok {
+		return nil, &contentNotFoundError{fmt.Sprintf("unknown program %s", slug)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	program = parseProgramPage(slug, page)
+	d.mu.Lock()
+	d.programs[slug] = program
+	d.mu.Unlock()
+	return program, nil
+}
+
+// parseProgramPage extracts program metadata from its page. The number of days
+// is taken from the day graphics the page links to, falling back to an
+// estimate from a "30 day" style mention in the description. The rest of the
+// page is never used, as navigation or instructions like "rest 2 days" would
+// be mistaken for the length of the program.
+func parseProgramPage(slug string, page string) *programInfo {
+	program := &programInfo{Slug: slug}
+	if matches := pageTitleRegexp.FindStringSubmatch(page); matches != nil {
+		program.Title = pageText(matches[1])
+	}
+	if program.Title == "" {
+		program.Title = slug
+	}
+	if matches := descriptionRegexp.FindStringSubmatch(page); matches != nil {
+		program.Description = pageText(matches[1])
+	}
+	if matches := difficultyRegexp.FindStringSubmatch(page); matches != nil {
+		program.Difficulty = matches[1]
+	}
+	for _, matches := range dayImageRegexp.FindAllStringSubmatch(page, -1) {
+		if day, _ := strconv.Atoi(matches[1]); day > program.Days {
+			program.Days = day
+		}
+	}
+	if program.Days == 0 {
+		if matches := dayCountRegexp.FindStringSubmatch(program.Description); matches != nil {
+			program.Days, _ = strconv.Atoi(matches[1])
+			program.DaysEstimated = true
+		}
+	}
+	return program
+}
+
+// pageText strips the markup from an HTML fragment.
+func pageText(fragment string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(tagRegexp.ReplaceAllString(fragment, " "))), " ")
+}
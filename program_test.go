@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+	"gotest.tools/assert"
+)
+
+const foundationPage = `
+<html>
+<head><meta name="description" content="A 30 day program to build a fitness base &amp; keep it."></head>
+<body>
+	<h1 class="title">Foundation <small>Program</small></h1>
+	<div class="info">Difficulty Level: <img src="/images/level2.png"/> 2</div>
+	<a href="/images/programs/foundation/web/day01.jpg"><img src="/images/programs/foundation/thumbs/day01.jpg"/></a>
+	<a href="/images/programs/foundation/web/day02.jpg">Day 2</a>
+	<a href="/images/programs/foundation/web/day30.jpg">Day 30</a>
+</body>
+</html>`
+
+func TestProgramDirectory(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/programs/foundation.html":
+			fmt.Fprint(w, foundationPage)
+		case "/programs/broken.html":
+			http.Error(w, "oops", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	programs := newProgramDirectory(server.Client(), server.URL)
+
+	t.Run("reads metadata from the program page", func(t *testing.T) {
+		program, err := programs.get(context.Background(), "Foundation")
+		assert.NilError(t, err)
+		assert.DeepEqual(t, &programInfo{
+			Slug:        "foundation",
+			Title:       "Foundation Program",
+			Days:        30,
+			Description: "A 30 day program to build a fitness base & keep it.",
+			Difficulty:  "2",
+		}, program)
+		assert.Equal(t, 1, requests)
+	})
+	t.Run("remembers programs", func(t *testing.T) {
+		_, err := programs.get(context.Background(), "foundation")
+		assert.NilError(t, err)
+		assert.Equal(t, 1, requests)
+	})
+	t.Run("unknown program", func(t *testing.T) {
+		_, err := programs.get(context.Background(), "nonexistent")
+		_, ok := err.(*contentNotFoundError)
+		assert.Assert(t, ok)
+		assert.Error(t, err, "unknown program nonexistent")
+	})
+	t.Run("upstream failure", func(t *testing.T) {
+		_, err := programs.get(context.Background(), "broken")
+		_, ok := err.(*contentNotFoundError)
+		assert.Assert(t, !ok)
+		assert.ErrorContains(t, err, "upstream returned 500")
+	})
+}
+
+func TestParseProgramPage(t *testing.T) {
+	t.Run("day count from the description", func(t *testing.T) {
+		program := parseProgramPage("ironborn", `<meta name="description" content="A 60-day program."><h1>Ironborn</h1>`)
+		assert.DeepEqual(t, &programInfo{Slug: "ironborn", Title: "Ironborn", Description: "A 60-day program.", Days: 60, DaysEstimated: true}, program)
+		assert.NilError(t, program.checkDay("61"))
+	})
+	t.Run("day counts elsewhere in the text are ignored", func(t *testing.T) {
+		program := parseProgramPage("ironborn", `<h1>Ironborn</h1><p>Rest 2 days between levels.</p><footer>Programs for 30 days</footer>`)
+		assert.DeepEqual(t, &programInfo{Slug: "ironborn", Title: "Ironborn"}, program)
+		assert.NilError(t, program.checkDay("3"))
+	})
+	t.Run("unknown day count", func(t *testing.T) {
+		program := parseProgramPage("ironborn", `<p>Nothing useful</p>`)
+		assert.DeepEqual(t, &programInfo{Slug: "ironborn", Title: "ironborn"}, program)
+	})
+}
+
+func TestCheckDay(t *testing.T) {
+	program := &programInfo{Slug: "foundation", Title: "Foundation", Days: 30}
+	assert.NilError(t, program.checkDay("1"))
+	assert.NilError(t, program.checkDay("30"))
+	assert.Error(t, program.checkDay("31"), "Foundation has no day 31, only days 1 to 30")
+	assert.Error(t, program.checkDay("0"), "Foundation has no day 0, only days 1 to 30")
+	assert.NilError(t, (&programInfo{Title: "Foundation"}).checkDay("99"))
+}
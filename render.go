@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// renderHTML writes the program and workout summary and graphic followed by a
+// video for each exercise. program is nil for content other than programs, or
+// if its metadata couldn't be found.
+func renderHTML(w http.ResponseWriter, imageURL string, program *programInfo, workout *Workout, exercises []exercise) {
+	w.Header().Set("Content-Type", "text/html")
+	if program != nil {
+		renderProgramHTML(w, program)
+	}
+	if workout != nil {
+		renderWorkoutHTML(w, workout)
+	}
+	fmt.Fprintf(w, `<img src="%s" /><br/>`, imageURL)
+	for _, exercise := range exercises {
+		fmt.Fprintf(w, `
+                   <h2>%s</h2>`, html.EscapeString(exercise.title()))
+		if exercise.fuzzyMatched() {
+			fmt.Fprintf(w, `
+                   <p><em>Read as &ldquo;%s&rdquo;, matched to %s (%.0f%% similar).</em></p>`, html.EscapeString(exercise.Text), exercise.Slug, exercise.MatchScore*100)
+		}
+		if exercise.lowConfidence(*minConfidence) {
+			fmt.Fprintf(w, `
+                   <p><em>Low OCR confidence (%.0f%%): this line may have been misread.</em></p>`, exercise.Confidence*100)
+		}
+		if media := exercise.media(); media != nil {
+			renderMediaHTML(w, media)
+		} else if exercise.Status == statusUpstreamError {
+			fmt.Fprint(w, `
+                   <p>Video unavailable: Darebee could not be reached. Try again later.</p>
+               `)
+		} else if exercise.Status == statusNoMedia {
+			fmt.Fprint(w, `
+                   <p>The exercise page has no video yet</p>
+               `)
+		} else {
+			fmt.Fprint(w, `
+                   <p>Video not found</p>
+               `)
+		}
+		if exercise.Details != nil {
+			renderDetailsHTML(w, exercise.Details)
+		}
+	}
+}
+
+// renderMediaHTML writes the player for an exercise's video.
+func renderMediaHTML(w io.Writer, media *exerciseMedia) {
+	source := html.EscapeString(media.Source)
+	switch media.Kind {
+	case mediaYouTube:
+		fmt.Fprintf(w, `
+                   <p>
+                       <iframe width="845" height="480" src="//www.youtube.com/embed/%s?rel=0&showinfo=0" frameborder="0" allowfullscreen></iframe>
+                   </p>`, source)
+	case mediaVimeo:
+		fmt.Fprintf(w, `
+                   <p>
+                       <iframe width="845" height="480" src="//player.vimeo.com/video/%s" frameborder="0" allowfullscreen></iframe>
+                   </p>`, source)
+	case mediaVideo:
+		fmt.Fprintf(w, `
+                   <p>
+                       <video width="845" src="%s" controls loop muted playsinline></video>
+                   </p>`, source)
+	case mediaImage:
+		fmt.Fprintf(w, `
+                   <p>
+                       <img width="845" src="%s" />
+                   </p>`, source)
+	}
+}
+
+// renderDetailsHTML writes what the exercise library says about an exercise.
+func renderDetailsHTML(w io.Writer, details *exerciseDetails) {
+	if details.ImageURL != "" {
+		fmt.Fprintf(w, `
+                   <p><img src="%s" alt="%s" width="400" /></p>`, html.EscapeString(details.ImageURL), html.EscapeString(details.Title))
+	}
+	if details.Description != "" {
+		fmt.Fprintf(w, `
+                   <p>%s</p>`, html.EscapeString(details.Description))
+	}
+	var facts []string
+	if len(details.Muscles) > 0 {
+		facts = append(facts, "Muscles: "+strings.Join(details.Muscles, ", "))
+	}
+	if details.Difficulty != "" {
+		facts = append(facts, "Difficulty: "+details.Difficulty)
+	}
+	if len(facts) > 0 {
+		fmt.Fprintf(w, `
+                   <p><em>%s</em></p>`, html.EscapeString(strings.Join(facts, " · ")))
+	}
+}
+
+// renderProgramHTML writes the program's details from its program page.
+func renderProgramHTML(w io.Writer, program *programInfo) {
+	fmt.Fprintf(w, `<p><strong>%s</strong>`, html.EscapeString(program.Title))
+	if program.Days > 0 {
+		fmt.Fprintf(w, ` &middot; %d days`, program.Days)
+	}
+	if program.Difficulty != "" {
+		fmt.Fprintf(w, ` &middot; difficulty %s`, html.EscapeString(program.Difficulty))
+	}
+	fmt.Fprint(w, `</p>`)
+	if program.Description != "" {
+		fmt.Fprintf(w, `<p>%s</p>`, html.EscapeString(program.Description))
+	}
+}
+
+// renderWorkoutHTML writes the title, levels and rest instructions that are
+// printed on the workout graphic.
+func renderWorkoutHTML(w io.Writer, workout *Workout) {
+	if workout.Title != "" {
+		fmt.Fprintf(w, `<h1>%s</h1>`, html.EscapeString(workout.Title))
+	}
+	if workout.Day != "" {
+		fmt.Fprintf(w, `<h3>%s</h3>`, html.EscapeString(workout.Day))
+	}
+	if len(workout.Levels) > 0 {
+		fmt.Fprint(w, `<ul>`)
+		for _, level := range workout.Levels {
+			fmt.Fprintf(w, `<li>%s: %d sets</li>`, level.Name, level.Sets)
+		}
+		fmt.Fprint(w, `</ul>`)
+	}
+	for _, rest := range workout.Rest {
+		fmt.Fprintf(w, `<p>%s</p>`, html.EscapeString(rest.Description))
+	}
+	for _, note := range workout.Notes {
+		fmt.Fprintf(w, `<p>%s</p>`, html.EscapeString(note))
+	}
+}
+
+type exerciseResponse struct {
+	exercise
+	LowConfidence bool `json:"lowConfidence"`
+}
+
+type workoutResponse struct {
+	ImageURL  string             `json:"imageURL"`
+	Program   *programInfo       `json:"program,omitempty"`
+	Workout   *Workout           `json:"workout,omitempty"`
+	Exercises []exerciseResponse `json:"exercises"`
+}
+
+// renderJSON writes the same information as renderHTML for API clients.
+func renderJSON(w http.ResponseWriter, imageURL string, program *programInfo, workout *Workout, exercises []exercise) {
+	response := workoutResponse{ImageURL: imageURL, Program: program, Workout: workout, Exercises: []exerciseResponse{}}
+	for _, exercise := range exercises {
+		response.Exercises = append(response.Exercises, exerciseResponse{
+			exercise:      exercise,
+			LowConfidence: exercise.lowConfidence(*minConfidence),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed writing JSON response for %s: %v", imageURL, err)
+	}
+}
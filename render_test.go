@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestLowConfidence(t *testing.T) {
+	assert.Assert(t, exercise{Confidence: 0.5}.lowConfidence(0.8))
+	assert.Assert(t, !exercise{Confidence: 0.9}.lowConfidence(0.8))
+	// unknown confidence is never flagged
+	assert.Assert(t, !exercise{}.lowConfidence(0.8))
+}
+
+func TestRender(t *testing.T) {
+	exercises := []exercise{
+		{Name: "knee strikes", Text: "20 knee strikes", Quantity: 20, Unit: "reps", EmbedURL: "abc123", Confidence: 0.98},
+		{Name: "jumpinq jacks", Text: "20 jumpinq jacks", Quantity: 20, Unit: "reps", Slug: "jumping-jacks", MatchScore: 0.93, Confidence: 0.42},
+	}
+	t.Run("html flags low confidence lines", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		renderHTML(w, "https://darebee.com/images/programs/foundation/web/day03.jpg", nil, nil, exercises)
+		body := w.Body.String()
+		assert.Assert(t, strings.Contains(body, "<h2>20 reps — knee strikes</h2>"))
+		assert.Equal(t, 1, strings.Count(body, "Low OCR confidence"))
+		assert.Assert(t, strings.Contains(body, "Low OCR confidence (42%)"))
+		assert.Assert(t, strings.Contains(body, "Read as &ldquo;20 jumpinq jacks&rdquo;, matched to jumping-jacks (93% similar)."))
+	})
+	t.Run("html escapes exercise titles", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		renderHTML(w, "https://darebee.com/images/programs/foundation/web/day03.jpg", nil, nil, []exercise{
+			{Name: "<b>squats</b> & lunges", Quantity: 10, Unit: "reps"},
+		})
+		assert.Assert(t, strings.Contains(w.Body.String(), "<h2>10 reps — &lt;b&gt;squats&lt;/b&gt; &amp; lunges</h2>"))
+	})
+	t.Run("html shows workout summary first", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		workout := &Workout{Title: "Foundation", Day: "Day 3 Fighter", Levels: []workoutLevel{{Name: "Level I", Sets: 3}}}
+		renderHTML(w, "https://darebee.com/images/programs/foundation/web/day03.jpg", nil, workout, exercises)
+		body := w.Body.String()
+		assert.Assert(t, strings.HasPrefix(body, "<h1>Foundation</h1><h3>Day 3 Fighter</h3><ul><li>Level I: 3 sets</li></ul><img"))
+	})
+	t.Run("html shows exercise details under the video", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		detailed := []exercise{{Name: "burpees", Quantity: 10, Unit: "reps", EmbedURL: "abc123", Details: &exerciseDetails{
+			Title:       "Burpees",
+			Description: "Drop & jump.",
+			Muscles:     []string{"chest", "quads"},
+			Difficulty:  "3 / 5",
+			ImageURL:    "https://darebee.com/images/exercises/burpees.jpg",
+		}}}
+		renderHTML(w, "https://darebee.com/images/programs/foundation/web/day03.jpg", nil, nil, detailed)
+		body := w.Body.String()
+		assert.Assert(t, strings.Index(body, "youtube.com/embed/abc123") < strings.Index(body, "<p>Drop &amp; jump.</p>"))
+		assert.Assert(t, strings.Contains(body, `<img src="https://darebee.com/images/exercises/burpees.jpg" alt="Burpees" width="400" />`))
+		assert.Assert(t, strings.Contains(body, "<p><em>Muscles: chest, quads · Difficulty: 3 / 5</em></p>"))
+	})
+	t.Run("html picks the player for each kind of media", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		renderHTML(w, "https://darebee.com/images/programs/foundation/web/day03.jpg", nil, nil, []exercise{
+			{Name: "plank", Media: &exerciseMedia{Kind: "vimeo", Source: "123456"}},
+			{Name: "skiers", Media: &exerciseMedia{Kind: "video", Source: "https://darebee.com/media/skiers.mp4"}},
+			{Name: "squats", Media: &exerciseMedia{Kind: "image", Source: "https://darebee.com/images/exercises/squats.gif"}},
+			{Name: "lunges"},
+			{Name: "burpees", Status: statusUpstreamError, Error: "GET https://darebee.com/exercises/burpees.html: upstream returned 503"},
+			{Name: "crunches", Status: statusNoMedia, Error: "exercise page https://darebee.com/exercises/crunches.html has no video"},
+		})
+		body := w.Body.String()
+		assert.Assert(t, strings.Contains(body, "<p>Video unavailable: Darebee could not be reached. Try again later.</p>"))
+		assert.Assert(t, strings.Contains(body, `src="//player.vimeo.com/video/123456"`))
+		assert.Assert(t, strings.Contains(body, `<video width="845" src="https://darebee.com/media/skiers.mp4" controls loop muted playsinline></video>`))
+		assert.Assert(t, strings.Contains(body, `<img width="845" src="https://darebee.com/images/exercises/squats.gif" />`))
+		assert.Assert(t, strings.Contains(body, "<p>The exercise page has no video yet</p>"))
+		assert.Equal(t, 1, strings.Count(body, "Video not found"))
+	})
+	t.Run("html shows program details", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		program := &programInfo{Slug: "foundation", Title: "Foundation", Days: 30, Difficulty: "2", Description: "Build a base & keep going."}
+		renderHTML(w, "https://darebee.com/images/programs/foundation/web/day03.jpg", program, nil, exercises)
+		body := w.Body.String()
+		assert.Assert(t, strings.HasPrefix(body, "<p><strong>Foundation</strong> &middot; 30 days &middot; difficulty 2</p><p>Build a base &amp; keep going.</p><img"))
+	})
+	t.Run("json includes program details", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		renderJSON(w, "https://darebee.com/images/programs/foundation/web/day03.jpg", &programInfo{Slug: "foundation", Title: "Foundation", Days: 30}, nil, exercises)
+		assert.Assert(t, strings.Contains(w.Body.String(), `"program":{"slug":"foundation","title":"Foundation","days":30}`))
+	})
+	t.Run("json flags low confidence lines", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		renderJSON(w, "https://darebee.com/images/programs/foundation/web/day03.jpg", nil, nil, exercises)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		var response struct {
+			Exercises []struct {
+				Name          string
+				LowConfidence bool
+			}
+		}
+		assert.NilError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, 2, len(response.Exercises))
+		assert.Assert(t, !response.Exercises[0].LowConfidence)
+		assert.Assert(t, response.Exercises[1].LowConfidence)
+		assert.Equal(t, "jumpinq jacks", response.Exercises[1].Name)
+	})
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// How looking up an exercise's video went.
+const (
+	statusResolved      = "resolved"
+	statusNotFound      = "not_found"
+	statusNoMedia       = "no_media"
+	statusUpstreamError = "upstream_error"
+)
+
+// Where an exercise's video was found.
+const (
+	resolvedByCatalog = "catalog"
+	resolvedByPage    = "page"
+	resolvedBySearch  = "search"
+)
+
+// exerciseError is an exercise whose video couldn't be looked up.
+type exerciseError struct {
+	Slug string
+	Err  error
+}
+
+// exerciseErrors collects every exercise that failed, so one bad page doesn't
+// hide the others.
+type exerciseErrors []exerciseError
+
+func (e exerciseErrors) Error() string {
+	var failures []string
+	for _, failure := range e {
+		failures = append(failures, fmt.Sprintf("%s: %v", failure.Slug, failure.Err))
+	}
+	return fmt.Sprintf("failed looking up %d exercises: %s", len(e), strings.Join(failures, "; "))
+}
+
+// resolveExercises fills in the video, details and status of each exercise,
+// from the catalog or the exercise cache where they have them and otherwise by
+// scraping the exercise pages (or searching for them), at most workers at a
+// time. Exercises keep their order. Exercises that fail are marked as such and
+// the rest are still resolved; the error, if any, is an exerciseErrors listing
+// every exercise that failed.
+func resolveExercises(ctx context.Context, exercises []exercise, catalog *exerciseCatalog, workers int) error {
+	var pending []int
+	for i := range exercises {
+		e := &exercises[i]
+		if entry, ok := catalog.entry(e.Slug); ok && entry.media() != nil {
+			e.EmbedURL, e.Media, e.Details = entry.EmbedID, entry.media(), entry.Details
+			e.Status, e.ResolvedBy = statusResolved, resolvedByCatalog
+		} else {
+			pending = append(pending, i)
+		}
+	}
+	errs := make([]error, len(pending))
+	forEachConcurrently(len(pending), workers, func(i int) {
+		e := &exercises[pending[i]]
+		guess := e.Slug
+		if err := ctx.Err(); err != nil {
+			// the request has gone away, so don't start on any more pages
+			errs[i] = err
+			e.Status, e.Error = statusUpstreamError, err.Error()
+			return
+		}
+		if slugCache.get(ctx, e) {
+			return
+		}
+		if err := resolveExercise(ctx, e); err != nil {
+			errs[i] = err
+			e.Status, e.Error = statusUpstreamError, err.Error()
+			return
+		}
+		slugCache.set(ctx, guess, *e)
+	})
+	var failures exerciseErrors
+	for i, err := range errs {
+		if err != nil {
+			failures = append(failures, exerciseError{Slug: exercises[pending[i]].Slug, Err: err})
+		}
+	}
+	if len(failures) > 0 {
+		return failures
+	}
+	return nil
+}
+
+// resolveExercise looks up the video on the exercise page for the guessed
+// slug. If that page doesn't exist or has no video, it falls back to searching
+// the site for the exercise by name. The search is best effort: if it fails,
+// the exercise keeps the status of its own page. Only upstream failures
+// fetching that page are returned as errors; an exercise that simply has no
+// video is marked as such.
+func resolveExercise(ctx context.Context, e *exercise) error {
+	e.ResolvedBy = resolvedByPage
+	page, err := lookupExercisePage(ctx, getVideoURL(e.Slug))
+	if _, unavailable := err.(*upstreamUnavailableError); unavailable {
+		return err
+	}
+	if err != nil {
+		result, ok, searchErr := searchExercise(ctx, e.Name, e.Slug, *searchThreshold)
+		if searchErr != nil {
+			log.Printf("Failed searching for %s: %v", e.Slug, searchErr)
+		}
+		if ok {
+			found, foundErr := lookupExercisePage(ctx, result.PageURL)
+			if _, unavailable := foundErr.(*upstreamUnavailableError); unavailable {
+				log.Printf("Failed fetching search result %s for %s: %v", result.PageURL, e.Slug, foundErr)
+			}
+			if foundErr == nil {
+				page, err = found, nil
+				e.Slug, e.MatchScore, e.ResolvedBy = result.Slug, result.Score, resolvedBySearch
+			}
+		}
+	}
+	if page != nil {
+		e.EmbedURL, e.Media, e.Details = page.EmbedID, page.Media, page.details()
+	}
+	switch err.(type) {
+	case nil:
+		e.Status = statusResolved
+	case *noMediaError:
+		e.Status, e.Error = statusNoMedia, err.Error()
+	default:
+		e.Status, e.Error = statusNotFound, err.Error()
+	}
+	if err != nil {
+		log.Printf("No video for %s: %v", e.Slug, err)
+	}
+	return nil
+}
+
+// forEachConcurrently calls fn for each index from 0 to n-1, running at most
+// workers calls at once, and returns when they have all finished.
+func forEachConcurrently(n int, workers int, fn func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
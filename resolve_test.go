@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"gotest.tools/assert"
+)
+
+// useOutbound sends upstream requests through t until the returned function is
+// called.
+func useOutbound(t *upstreamTransport) func() {
+	original := outbound
+	outbound = t
+	return func() { outbound = original }
+}
+
+func TestResolveExercises(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	var searches []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		time.Sleep(10 * time.Millisecond)
+		switch r.URL.Path {
+		case "/exercises/broken-exercise.html", "/exercises/also-broken.html":
+			http.Error(w, "oops", http.StatusInternalServerError)
+		case "/exercises/no-video.html":
+			fmt.Fprint(w, `<p>Coming soon</p>`)
+		case "/exercises/side-chops.html", "/exercises/jumping-jack.html":
+			http.NotFound(w, r)
+		case "/search.html":
+			searches = append(searches, r.URL.Query().Get("searchword"))
+			fmt.Fprint(w, `
+				<dl class="search-results">
+					<dt><a href="/exercises/side-chops-drill.html">Side Chops Drill</a></dt>
+					<dt><a href="/exercises/side-to-side-chops.html">Side-to-Side Chops</a></dt>
+					<dt><a href="/blog/jumping-jack-history.html">The history of the jumping jack</a></dt>
+				</dl>`)
+		default:
+			fmt.Fprintf(w, `<iframe src="https://www.youtube.com/embed/%s?rel=0"></iframe>`, r.URL.Path[len("/exercises/"):len(r.URL.Path)-len(".html")])
+		}
+	}))
+	defer server.Close()
+	defer useUpstream(server.URL)()
+	defer useOutbound(newUpstreamTransport(server.Client().Transport, upstreamOptions{}))()
+	catalog := newExerciseCatalog([]catalogEntry{{Slug: "knee-strikes", EmbedID: "kneeID"}})
+
+	t.Run("resolves in order with bounded concurrency", func(t *testing.T) {
+		exercises := []exercise{{Slug: "a"}, {Slug: "knee-strikes"}, {Slug: "b"}, {Slug: "c"}, {Slug: "d"}, {Slug: "e"}}
+		assert.NilError(t, resolveExercises(context.Background(), exercises, catalog, 2))
+		var embedIDs []string
+		for _, e := range exercises {
+			embedIDs = append(embedIDs, e.EmbedURL)
+		}
+		assert.DeepEqual(t, []string{"a", "kneeID", "b", "c", "d", "e"}, embedIDs)
+		assert.Equal(t, 2, maxInFlight)
+	})
+	t.Run("collects every failure", func(t *testing.T) {
+		exercises := []exercise{{Slug: "broken-exercise"}, {Slug: "a"}, {Slug: "also-broken"}}
+		err := resolveExercises(context.Background(), exercises, catalog, 4)
+		failures, ok := err.(exerciseErrors)
+		assert.Assert(t, ok)
+		assert.Equal(t, 2, len(failures))
+		assert.Equal(t, "broken-exercise", failures[0].Slug)
+		assert.Equal(t, "also-broken", failures[1].Slug)
+		assert.Equal(t, "a", exercises[1].EmbedURL)
+		assert.Equal(t, statusUpstreamError, exercises[0].Status)
+		assert.Equal(t, statusResolved, exercises[1].Status)
+		assert.ErrorContains(t, failures[0].Err, "upstream returned 500")
+		assert.Equal(t, failures[0].Err.Error(), exercises[0].Error)
+	})
+	t.Run("pages without a video", func(t *testing.T) {
+		exercises := []exercise{{Slug: "no-video"}}
+		assert.NilError(t, resolveExercises(context.Background(), exercises, catalog, 4))
+		assert.Equal(t, statusNoMedia, exercises[0].Status)
+		assert.Equal(t, (&noMediaError{URL: getVideoURL("no-video")}).Error(), exercises[0].Error)
+	})
+	t.Run("falls back to site search", func(t *testing.T) {
+		exercises := []exercise{{Name: "side chops", Slug: "side-chops"}}
+		assert.NilError(t, resolveExercises(context.Background(), exercises, catalog, 4))
+		assert.Equal(t, "side-to-side-chops", exercises[0].EmbedURL)
+		assert.Equal(t, "side-to-side-chops", exercises[0].Slug)
+		assert.Equal(t, resolvedBySearch, exercises[0].ResolvedBy)
+		assert.Assert(t, exercises[0].MatchScore >= *searchThreshold)
+		assert.Equal(t, statusResolved, exercises[0].Status)
+		assert.Equal(t, "side chops", searches[len(searches)-1])
+	})
+	t.Run("search without a good match", func(t *testing.T) {
+		exercises := []exercise{{Slug: "jumping-jack"}}
+		assert.NilError(t, resolveExercises(context.Background(), exercises, catalog, 4))
+		assert.Equal(t, statusNotFound, exercises[0].Status)
+		assert.Equal(t, (&pageNotFoundError{URL: getVideoURL("jumping-jack")}).Error(), exercises[0].Error)
+		assert.Equal(t, resolvedByPage, exercises[0].ResolvedBy)
+		assert.Equal(t, "jumping jack", searches[len(searches)-1])
+	})
+}
+
+func TestResolveExerciseSearchFailures(t *testing.T) {
+	searchStatus := http.StatusNotFound
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/exercises/no-video.html":
+			fmt.Fprint(w, `<p>Coming soon</p>`)
+		case "/search.html":
+			http.Error(w, "search unavailable", searchStatus)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	defer useUpstream(server.URL)()
+	defer useOutbound(newUpstreamTransport(server.Client().Transport, upstreamOptions{}))()
+	catalog := newExerciseCatalog(nil)
+
+	for _, status := range []int{http.StatusNotFound, http.StatusInternalServerError} {
+		searchStatus = status
+		t.Run(fmt.Sprintf("search returning %d", status), func(t *testing.T) {
+			exercises := []exercise{{Slug: "made-up"}, {Slug: "no-video"}}
+			assert.NilError(t, resolveExercises(context.Background(), exercises, catalog, 1))
+			assert.Equal(t, statusNotFound, exercises[0].Status)
+			assert.Equal(t, statusNoMedia, exercises[1].Status)
+			now := time.Now()
+			assert.Equal(t, now.Add(*noMediaCacheTTL), cacheExpiry(exercises, now))
+		})
+	}
+	t.Run("search errors are typed", func(t *testing.T) {
+		searchStatus = http.StatusNotFound
+		_, err := searchExercises(context.Background(), "made up")
+		_, ok := err.(*pageNotFoundError)
+		assert.Assert(t, ok, "got %v", err)
+		searchStatus = http.StatusInternalServerError
+		_, err = searchExercises(context.Background(), "made up")
+		unavailable, ok := err.(*upstreamUnavailableError)
+		assert.Assert(t, ok, "got %v", err)
+		assert.Equal(t, http.StatusInternalServerError, unavailable.StatusCode)
+	})
+}
+
+func TestResolveExercisesCancelled(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, `<iframe src="https://www.youtube.com/embed/abc123?rel=0"></iframe>`)
+	}))
+	defer server.Close()
+	defer useUpstream(server.URL)()
+	defer useOutbound(newUpstreamTransport(server.Client().Transport, upstreamOptions{}))()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	exercises := []exercise{{Slug: "burpees"}, {Slug: "squats"}}
+	err := resolveExercises(ctx, exercises, newExerciseCatalog(nil), 1)
+	failures, ok := err.(exerciseErrors)
+	assert.Assert(t, ok)
+	assert.Equal(t, 2, len(failures))
+	assert.Equal(t, 0, requests)
+	assert.Equal(t, statusUpstreamError, exercises[0].Status)
+}
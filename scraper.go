@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// exerciseDetails is what an exercise page in the Darebee library says about
+// the exercise, shown alongside its video.
+type exerciseDetails struct {
+	Title       string   `json:"title,omitempty" firestore:"title,omitempty"`
+	Description string   `json:"description,omitempty" firestore:"description,omitempty"`
+	Muscles     []string `json:"muscles,omitempty" firestore:"muscles,omitempty"`
+	Difficulty  string   `json:"difficulty,omitempty" firestore:"difficulty,omitempty"`
+	ImageURL    string   `json:"imageURL,omitempty" firestore:"imageURL,omitempty"`
+}
+
+// Kinds of media that demonstrate an exercise.
+const (
+	mediaYouTube = "youtube"
+	mediaVimeo   = "vimeo"
+	mediaVideo   = "video"
+	mediaImage   = "image"
+)
+
+// exerciseMedia is the video (or animation) demonstrating an exercise. Source
+// is the video ID for YouTube and Vimeo, and the file's URL otherwise.
+type exerciseMedia struct {
+	Kind   string `json:"kind" firestore:"kind"`
+	Source string `json:"source" firestore:"source"`
+}
+
+// youtubeMedia returns the media for a YouTube video ID, or nil if there is none.
+func youtubeMedia(embedID string) *exerciseMedia {
+	if embedID == "" {
+		return nil
+	}
+	return &exerciseMedia{Kind: mediaYouTube, Source: embedID}
+}
+
+// exercisePage is a scraped exercise page: its video and its details. EmbedID
+// is set only if the video is on YouTube.
+type exercisePage struct {
+	EmbedID string
+	Media   *exerciseMedia
+	Details exerciseDetails
+}
+
+// details returns the exercise details, or nil if the page had none.
+func (p *exercisePage) details() *exerciseDetails {
+	d := p.Details
+	if d.Title == "" && d.Description == "" && len(d.Muscles) == 0 && d.Difficulty == "" && d.ImageURL == "" {
+		return nil
+	}
+	return &d
+}
+
+var (
+	embedIDRegexp    = regexp.MustCompile(`youtube(?:-nocookie)?\.com/embed/([^?/"]+)`)
+	vimeoIDRegexp    = regexp.MustCompile(`vimeo\.com/(?:video/)?(\d+)`)
+	videoFileRegexp  = regexp.MustCompile(`(?i)\.(mp4|webm|ogv)(\?|$)`)
+	labelRegexp      = regexp.MustCompile(`(?i)^(?:(?:target|primary)\s+)?(muscles?(?:\s+worked)?|focus|difficulty)\s*:\s*(.*)$`)
+	listSplitRegexp  = regexp.MustCompile(`\s*(?:,|&|\band\b)\s*`)
+	exerciseImageDir = "/images/exercises/"
+)
+
+// pageNotFoundError is returned for Darebee pages that don't exist. For
+// exercise pages that is usually because the slug was guessed wrong.
+type pageNotFoundError struct {
+	URL string
+}
+
+func (e *pageNotFoundError) Error() string {
+	return fmt.Sprintf("page %s not found", e.URL)
+}
+
+// noMediaError is returned for exercise pages that exist but have no video.
+type noMediaError struct {
+	URL string
+}
+
+func (e *noMediaError) Error() string {
+	return fmt.Sprintf("exercise page %s has no video", e.URL)
+}
+
+// upstreamUnavailableError is returned when Darebee can't be reached or fails
+// to serve a page. Unlike the other errors it says nothing about the page
+// itself, so is worth retrying later.
+type upstreamUnavailableError struct {
+	URL        string
+	StatusCode int
+	Err        error
+}
+
+func (e *upstreamUnavailableError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("GET %s: %v", e.URL, e.Err)
+	}
+	return fmt.Sprintf("GET %s: upstream returned %d", e.URL, e.StatusCode)
+}
+
+// scrapeExercisePage downloads and parses an exercise page, returning a
+// pageNotFoundError if it doesn't exist and an upstreamUnavailableError if it
+// couldn't be fetched.
+func scrapeExercisePage(ctx context.Context, client *http.Client, pageURL string) (*exercisePage, error) {
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, &upstreamUnavailableError{URL: pageURL, Err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &pageNotFoundError{URL: pageURL}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &upstreamUnavailableError{URL: pageURL, StatusCode: resp.StatusCode}
+	}
+	page, err := parseExercisePage(pageURL, resp.Body)
+	if err != nil {
+		return nil, &upstreamUnavailableError{URL: pageURL, Err: err}
+	}
+	return page, nil
+}
+
+// lookupExercisePage is scrapeExercisePage for when the video is what matters:
+// a page without one is returned along with a noMediaError.
+func lookupExercisePage(ctx context.Context, pageURL string) (*exercisePage, error) {
+	page, err := scrapeExercisePage(ctx, outbound.client(), pageURL)
+	if err != nil {
+		return nil, err
+	}
+	if page.Media == nil {
+		return page, &noMediaError{URL: pageURL}
+	}
+	return page, nil
+}
+
+// parseExercisePage extracts the video and details from an exercise page. If
+// the page has more than one kind of media, YouTube is preferred, then Vimeo,
+// then video files and lastly animated GIFs. The description comes from the page's meta description or, failing that, its
+// first paragraph; muscles and difficulty from "Muscles: ..." and
+// "Difficulty: ..." labels.
+func parseExercisePage(pageURL string, r io.Reader) (*exercisePage, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	page := &exercisePage{}
+	var firstParagraph, exerciseImage string
+	var vimeoID, videoURL, animationURL string
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Iframe:
+				for _, src := range []string{attr(n, "src"), attr(n, "data-src")} {
+					if matches := embedIDRegexp.FindStringSubmatch(src); matches != nil && page.EmbedID == "" {
+						page.EmbedID = matches[1]
+					}
+					if matches := vimeoIDRegexp.FindStringSubmatch(src); matches != nil && vimeoID == "" {
+						vimeoID = matches[1]
+					}
+				}
+			case atom.Video, atom.Source, atom.A:
+				for _, src := range []string{attr(n, "src"), attr(n, "href")} {
+					if videoFileRegexp.MatchString(src) && videoURL == "" {
+						videoURL = src
+					}
+				}
+			case atom.H1:
+				if page.Details.Title == "" {
+					page.Details.Title = nodeText(n)
+				}
+			case atom.Meta:
+				switch {
+				case attr(n, "name") == "description" && page.Details.Description == "":
+					page.Details.Description = collapseSpace(attr(n, "content"))
+				case attr(n, "property") == "og:image" && page.Details.ImageURL == "":
+					page.Details.ImageURL = attr(n, "content")
+				case attr(n, "property") == "og:title" && page.Details.Title == "":
+					page.Details.Title = collapseSpace(attr(n, "content"))
+				}
+			case atom.Img:
+				if src := attr(n, "src"); strings.Contains(src, exerciseImageDir) {
+					if exerciseImage == "" {
+						exerciseImage = src
+					}
+					if animationURL == "" && strings.HasSuffix(strings.ToLower(src), ".gif") {
+						animationURL = src
+					}
+				}
+			case atom.P, atom.Li, atom.Div, atom.Span, atom.Dd:
+				text := nodeText(n)
+				if matches := labelRegexp.FindStringSubmatch(text); matches != nil && !hasBlockChild(n) {
+					page.Details.addLabel(matches[1], matches[2])
+				} else if n.DataAtom == atom.P && firstParagraph == "" {
+					firstParagraph = text
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	visit(doc)
+	if page.Details.Description == "" {
+		page.Details.Description = firstParagraph
+	}
+	if page.Details.ImageURL == "" {
+		page.Details.ImageURL = exerciseImage
+	}
+	page.Details.ImageURL = resolveMediaLink(pageURL, page.Details.ImageURL)
+	switch {
+	case page.EmbedID != "":
+		page.Media = youtubeMedia(page.EmbedID)
+	case vimeoID != "":
+		page.Media = &exerciseMedia{Kind: mediaVimeo, Source: vimeoID}
+	case videoURL != "":
+		page.Media = &exerciseMedia{Kind: mediaVideo, Source: resolveMediaLink(pageURL, videoURL)}
+	case animationURL != "":
+		page.Media = &exerciseMedia{Kind: mediaImage, Source: resolveMediaLink(pageURL, animationURL)}
+	}
+	return page, nil
+}
+
+// resolveMediaLink makes a link found on the page absolute, leaving it as is if
+// it can't be parsed.
+func resolveMediaLink(pageURL string, href string) string {
+	if href == "" {
+		return ""
+	}
+	resolved, err := resolveLink(pageURL, href)
+	if err != nil {
+		return href
+	}
+	return resolved
+}
+
+func (d *exerciseDetails) addLabel(label string, value string) {
+	label = strings.ToLower(label)
+	switch {
+	case label == "difficulty":
+		if d.Difficulty == "" {
+			d.Difficulty = value
+		}
+	case len(d.Muscles) == 0:
+		for _, muscle := range listSplitRegexp.Split(value, -1) {
+			if muscle != "" {
+				d.Muscles = append(d.Muscles, strings.ToLower(muscle))
+			}
+		}
+	}
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// nodeText is the text content of n with whitespace collapsed.
+func nodeText(n *html.Node) string {
+	var b bytes.Buffer
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	visit(n)
+	return collapseSpace(b.String())
+}
+
+// hasBlockChild reports whether n contains other blocks, in which case any
+// label belongs to the innermost block holding it.
+func hasBlockChild(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && (c.DataAtom == atom.P || c.DataAtom == atom.Div || c.DataAtom == atom.Li || c.DataAtom == atom.Dd) {
+			return true
+		}
+		if hasBlockChild(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func collapseSpace(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"gotest.tools/assert"
+)
+
+const burpeesPage = `<!DOCTYPE html>
+<html>
+<head>
+	<meta name="description" content="Start standing, drop into a plank, do a push-up &amp; jump back up.">
+	<meta property="og:image" content="/images/exercises/burpees-with-push-up.jpg">
+</head>
+<body>
+	<div class="item-page">
+		<h1 itemprop="headline">Burpees <small>with</small> Push-Up</h1>
+		<iframe width="560" height="315" src="https://www.youtube.com/embed/ZQzikdjmkKg?rel=0&amp;showinfo=0" frameborder="0"></iframe>
+		<div class="info">
+			<p><strong>Muscles:</strong> Chest, Triceps &amp; Quads</p>
+			<p><strong>Difficulty:</strong> 3 / 5</p>
+		</div>
+		<p>Keep your core tight throughout.</p>
+	</div>
+</body>
+</html>`
+
+func TestParseExercisePage(t *testing.T) {
+	t.Run("full page", func(t *testing.T) {
+		page, err := parseExercisePage("https://darebee.com/exercises/burpees-with-push-up.html", strings.NewReader(burpeesPage))
+		assert.NilError(t, err)
+		assert.DeepEqual(t, &exercisePage{
+			EmbedID: "ZQzikdjmkKg",
+			Media:   &exerciseMedia{Kind: "youtube", Source: "ZQzikdjmkKg"},
+			Details: exerciseDetails{
+				Title:       "Burpees with Push-Up",
+				Description: "Start standing, drop into a plank, do a push-up & jump back up.",
+				Muscles:     []string{"chest", "triceps", "quads"},
+				Difficulty:  "3 / 5",
+				ImageURL:    "https://darebee.com/images/exercises/burpees-with-push-up.jpg",
+			},
+		}, page)
+	})
+	t.Run("description from first paragraph and lazy loaded video", func(t *testing.T) {
+		page, err := parseExercisePage("https://darebee.com/exercises/skiers.html", strings.NewReader(`
+			<h1>Skiers</h1>
+			<iframe data-src="https://www.youtube-nocookie.com/embed/skiID"></iframe>
+			<p>Jump from side to side.</p>
+			<img src="/images/exercises/skiers.gif">`))
+		assert.NilError(t, err)
+		assert.Equal(t, "skiID", page.EmbedID)
+		assert.Equal(t, "Jump from side to side.", page.Details.Description)
+		assert.Equal(t, "https://darebee.com/images/exercises/skiers.gif", page.Details.ImageURL)
+	})
+	t.Run("page without details", func(t *testing.T) {
+		page, err := parseExercisePage("https://darebee.com/exercises/plank.html", strings.NewReader(`<iframe src="https://www.youtube.com/embed/plankID?rel=0"></iframe>`))
+		assert.NilError(t, err)
+		assert.Equal(t, "plankID", page.EmbedID)
+		assert.Assert(t, page.details() == nil)
+	})
+}
+
+func TestParseExercisePageMedia(t *testing.T) {
+	tests := []struct {
+		name  string
+		page  string
+		media *exerciseMedia
+	}{
+		{
+			name:  "youtube-nocookie",
+			page:  `<iframe src="https://www.youtube-nocookie.com/embed/ytID?rel=0"></iframe>`,
+			media: &exerciseMedia{Kind: "youtube", Source: "ytID"},
+		},
+		{
+			name:  "vimeo",
+			page:  `<iframe src="https://player.vimeo.com/video/123456?title=0"></iframe>`,
+			media: &exerciseMedia{Kind: "vimeo", Source: "123456"},
+		},
+		{
+			name:  "video file",
+			page:  `<video autoplay loop><source src="/media/exercises/plank.mp4" type="video/mp4"></video>`,
+			media: &exerciseMedia{Kind: "video", Source: "https://darebee.com/media/exercises/plank.mp4"},
+		},
+		{
+			name:  "animated gif",
+			page:  `<img src="/images/exercises/plank.gif">`,
+			media: &exerciseMedia{Kind: "image", Source: "https://darebee.com/images/exercises/plank.gif"},
+		},
+		{
+			name:  "youtube preferred over gif",
+			page:  `<img src="/images/exercises/plank.gif"><iframe src="https://www.youtube.com/embed/ytID?rel=0"></iframe>`,
+			media: &exerciseMedia{Kind: "youtube", Source: "ytID"},
+		},
+		{
+			name:  "still images are not media",
+			page:  `<img src="/images/exercises/plank.jpg">`,
+			media: nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page, err := parseExercisePage("https://darebee.com/exercises/plank.html", strings.NewReader(test.page))
+			assert.NilError(t, err)
+			assert.DeepEqual(t, test.media, page.Media)
+		})
+	}
+}
+
+func TestScrapeExercisePage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/exercises/burpees-with-push-up.html" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, burpeesPage)
+	}))
+	defer server.Close()
+
+	t.Run("existing page", func(t *testing.T) {
+		page, err := scrapeExercisePage(context.Background(), server.Client(), server.URL+"/exercises/burpees-with-push-up.html")
+		assert.NilError(t, err)
+		assert.Equal(t, "ZQzikdjmkKg", page.EmbedID)
+		assert.Equal(t, server.URL+"/images/exercises/burpees-with-push-up.jpg", page.Details.ImageURL)
+	})
+	t.Run("missing page", func(t *testing.T) {
+		_, err := scrapeExercisePage(context.Background(), server.Client(), server.URL+"/exercises/nothing.html")
+		notFound, ok := err.(*pageNotFoundError)
+		assert.Assert(t, ok, "got %v", err)
+		assert.Equal(t, server.URL+"/exercises/nothing.html", notFound.URL)
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"html"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// searchResult is an exercise page found by the site search, scored by how
+// closely its slug matches the one guessed from OCR.
+type searchResult struct {
+	Slug    string
+	Name    string
+	PageURL string
+	Score   float64
+}
+
+// searchExercises runs the Darebee site search for query and returns the
+// exercise pages among the results, in the order the search ranked them.
+func searchExercises(ctx context.Context, query string) ([]searchResult, error) {
+	searchURL := upstream("/search.html?searchphrase=all&searchword=" + url.QueryEscape(query))
+	body, err := fetchPage(ctx, outbound.client(), searchURL)
+	if err != nil {
+		return nil, err
+	}
+	var results []searchResult
+	seen := map[string]bool{}
+	for _, link := range exerciseLinkRegexp.FindAllStringSubmatch(body, -1) {
+		slug := link[2]
+		if seen[slug] {
+			continue
+		}
+		seen[slug] = true
+		pageURL, err := resolveLink(searchURL, link[1])
+		if err != nil {
+			return nil, err
+		}
+		name := strings.TrimSpace(html.UnescapeString(tagRegexp.ReplaceAllString(link[3], "")))
+		results = append(results, searchResult{Slug: slug, Name: name, PageURL: pageURL})
+	}
+	return results, nil
+}
+
+// searchExercise looks for an exercise by name when its guessed slug doesn't
+// lead to a video. ok is false unless a result scores at least threshold
+// against the guess; the best scoring result wins, ties going to the search's
+// own ranking.
+func searchExercise(ctx context.Context, name string, guess string, threshold float64) (best searchResult, ok bool, err error) {
+	if name == "" {
+		name = strings.Replace(strings.TrimSuffix(guess, "-exercise"), "-", " ", -1)
+	}
+	results, err := searchExercises(ctx, name)
+	if err != nil {
+		return best, false, err
+	}
+	for _, result := range results {
+		if result.Slug == guess {
+			// the guess itself, which is already known to have no video
+			continue
+		}
+		result.Score = slugSimilarity(guess, result.Slug)
+		if result.Score > best.Score {
+			best = result
+		}
+	}
+	return best, best.Score >= threshold, nil
+}
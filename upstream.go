@@ -0,0 +1,192 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const upstreamUserAgent = "darebee-workout (+https://github.com/robwil/darebee-workout)"
+
+// upstreamOptions configures how the service talks to Darebee.
+type upstreamOptions struct {
+	// Timeout limits each attempt at a request, not the request as a whole.
+	Timeout time.Duration
+	// Retries is how many times a request is retried after a network error or
+	// a 5xx or 429 response, waiting Backoff before the first retry and twice
+	// as long before each one after.
+	Retries int
+	Backoff time.Duration
+	// Rate is the most requests per second sent to any one host. Zero disables
+	// rate limiting.
+	Rate float64
+}
+
+func defaultUpstreamOptions() upstreamOptions {
+	return upstreamOptions{Timeout: 10 * time.Second, Retries: 3, Backoff: 200 * time.Millisecond, Rate: 5}
+}
+
+// upstreamMetrics counts what happened to outbound requests.
+type upstreamMetrics struct {
+	Requests    int64 `json:"requests"`
+	Retries     int64 `json:"retries"`
+	Failures    int64 `json:"failures"`
+	RateLimited int64 `json:"rateLimited"`
+}
+
+// upstreamTransport is the transport for every outbound request to Darebee. It
+// identifies the service with a User-Agent, times out and retries failed
+// attempts with exponential backoff, and spaces out requests to each host.
+// Only requests without a body (GET and HEAD) are retried.
+type upstreamTransport struct {
+	base http.RoundTripper
+	opts upstreamOptions
+
+	mu       sync.Mutex
+	nextSlot map[string]time.Time
+
+	metrics upstreamMetrics
+}
+
+func newUpstreamTransport(base http.RoundTripper, opts upstreamOptions) *upstreamTransport {
+	return &upstreamTransport{base: base, opts: opts, nextSlot: map[string]time.Time{}}
+}
+
+// outbound is shared by everything that fetches from Darebee, so rate limits
+// apply across the whole service. main replaces it once flags are parsed.
+var outbound = newUpstreamTransport(http.DefaultTransport, defaultUpstreamOptions())
+
+// client returns an HTTP client that sends its requests through t.
+func (t *upstreamTransport) client() *http.Client {
+	return &http.Client{Transport: t}
+}
+
+// snapshot returns the current counters.
+func (t *upstreamTransport) snapshot() upstreamMetrics {
+	return upstreamMetrics{
+		Requests:    atomic.LoadInt64(&t.metrics.Requests),
+		Retries:     atomic.LoadInt64(&t.metrics.Retries),
+		Failures:    atomic.LoadInt64(&t.metrics.Failures),
+		RateLimited: atomic.LoadInt64(&t.metrics.RateLimited),
+	}
+}
+
+func (t *upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = cloneRequest(req)
+		req.Header.Set("User-Agent", upstreamUserAgent)
+	}
+	ctx := req.Context()
+	retryable := req.Body == nil || req.Body == http.NoBody
+	backoff := t.opts.Backoff
+	for attempt := 0; ; attempt++ {
+		if err := t.wait(ctx, req.URL.Host); err != nil {
+			return nil, err
+		}
+		atomic.AddInt64(&t.metrics.Requests, 1)
+		resp, err := t.attempt(req)
+		if !retryable || attempt >= t.opts.Retries || !shouldRetry(ctx, resp, err) {
+			if err != nil || resp.StatusCode >= 500 {
+				atomic.AddInt64(&t.metrics.Failures, 1)
+			}
+			return resp, err
+		}
+		if err != nil {
+			log.Printf("Retrying %s %s after error: %v", req.Method, req.URL, err)
+		} else {
+			log.Printf("Retrying %s %s after upstream returned %d", req.Method, req.URL, resp.StatusCode)
+			resp.Body.Close()
+		}
+		atomic.AddInt64(&t.metrics.Retries, 1)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// attempt sends req once, limited by the per-attempt timeout. The timeout keeps
+// running while the caller reads the body.
+func (t *upstreamTransport) attempt(req *http.Request) (*http.Response, error) {
+	if t.opts.Timeout <= 0 {
+		return t.base.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.opts.Timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// wait blocks until the next request to host may be sent.
+func (t *upstreamTransport) wait(ctx context.Context, host string) error {
+	if t.opts.Rate <= 0 {
+		return nil
+	}
+	interval := time.Duration(float64(time.Second) / t.opts.Rate)
+	t.mu.Lock()
+	now := time.Now()
+	slot := t.nextSlot[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	t.nextSlot[host] = slot.Add(interval)
+	t.mu.Unlock()
+	delay := slot.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+	atomic.AddInt64(&t.metrics.RateLimited, 1)
+	select {
+	case <-time.After(delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shouldRetry reports whether a failed attempt is worth retrying: network
+// errors and timeouts, server errors and rate limiting, but not the caller
+// giving up.
+func shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		// the transport only fails on network errors and timeouts
+		return true
+	}
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+}
+
+func cloneRequest(req *http.Request) *http.Request {
+	clone := new(http.Request)
+	*clone = *req
+	clone.Header = make(http.Header, len(req.Header))
+	for key, values := range req.Header {
+		clone.Header[key] = append([]string(nil), values...)
+	}
+	return clone
+}
+
+// cancelOnClose releases a request's timeout once its body has been read.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"gotest.tools/assert"
+)
+
+func TestUpstreamTransport(t *testing.T) {
+	var mu sync.Mutex
+	failures := map[string]int{"/flaky": 2, "/down": 100}
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		userAgent = r.Header.Get("User-Agent")
+		switch {
+		case r.URL.Path == "/slow":
+			mu.Unlock()
+			time.Sleep(100 * time.Millisecond)
+			mu.Lock()
+		case r.URL.Path == "/missing":
+			http.NotFound(w, r)
+			return
+		case failures[r.URL.Path] > 0:
+			failures[r.URL.Path]--
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+	newTransport := func(opts upstreamOptions) *upstreamTransport {
+		return newUpstreamTransport(server.Client().Transport, opts)
+	}
+
+	t.Run("retries server errors", func(t *testing.T) {
+		transport := newTransport(upstreamOptions{Retries: 3, Backoff: time.Millisecond})
+		resp, err := transport.client().Get(server.URL + "/flaky")
+		assert.NilError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, upstreamMetrics{Requests: 3, Retries: 2}, transport.snapshot())
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, upstreamUserAgent, userAgent)
+	})
+	t.Run("gives up after too many retries", func(t *testing.T) {
+		transport := newTransport(upstreamOptions{Retries: 2, Backoff: time.Millisecond})
+		resp, err := transport.client().Get(server.URL + "/down")
+		assert.NilError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.Equal(t, upstreamMetrics{Requests: 3, Retries: 2, Failures: 1}, transport.snapshot())
+	})
+	t.Run("does not retry client errors", func(t *testing.T) {
+		transport := newTransport(upstreamOptions{Retries: 3, Backoff: time.Millisecond})
+		resp, err := transport.client().Get(server.URL + "/missing")
+		assert.NilError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Equal(t, upstreamMetrics{Requests: 1}, transport.snapshot())
+	})
+	t.Run("times out each attempt", func(t *testing.T) {
+		transport := newTransport(upstreamOptions{Timeout: 20 * time.Millisecond, Retries: 1, Backoff: time.Millisecond})
+		_, err := transport.client().Get(server.URL + "/slow")
+		assert.Assert(t, err != nil)
+		assert.Equal(t, upstreamMetrics{Requests: 2, Retries: 1, Failures: 1}, transport.snapshot())
+	})
+	t.Run("stops retrying when the caller gives up", func(t *testing.T) {
+		mu.Lock()
+		failures["/flaky"] = 2
+		mu.Unlock()
+		transport := newTransport(upstreamOptions{Retries: 3, Backoff: time.Hour})
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		req, err := http.NewRequest("GET", server.URL+"/flaky", nil)
+		assert.NilError(t, err)
+		_, err = transport.client().Do(req.WithContext(ctx))
+		assert.Assert(t, err != nil)
+		assert.Equal(t, int64(1), transport.snapshot().Requests)
+	})
+	t.Run("rate limits each host", func(t *testing.T) {
+		transport := newTransport(upstreamOptions{Rate: 50})
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			resp, err := transport.client().Get(server.URL + "/ok")
+			assert.NilError(t, err)
+			resp.Body.Close()
+		}
+		assert.Assert(t, time.Since(start) >= 40*time.Millisecond)
+		assert.Equal(t, int64(2), transport.snapshot().RateLimited)
+	})
+}
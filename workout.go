@@ -0,0 +1,248 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Workout is the structure printed on a Darebee workout graphic, besides the
+// exercise videos themselves.
+type Workout struct {
+	Title     string            `json:"title"`
+	Day       string            `json:"day,omitempty"`
+	Levels    []workoutLevel    `json:"levels,omitempty"`
+	Rest      []restInterval    `json:"rest,omitempty"`
+	Exercises []workoutExercise `json:"exercises"`
+	Notes     []string          `json:"notes,omitempty"`
+}
+
+// workoutLevel is a difficulty level, e.g. "Level II 5 sets".
+type workoutLevel struct {
+	Name string `json:"name"`
+	Sets int    `json:"sets"`
+}
+
+// restInterval is a rest instruction, e.g. "2 minutes rest between sets".
+type restInterval struct {
+	Seconds     int    `json:"seconds"`
+	Description string `json:"description"`
+}
+
+// workoutExercise is an exercise line split into its parts, e.g.
+// "10 lunges per leg" is a Quantity of 10 reps of "lunges", per leg.
+type workoutExercise struct {
+	Quantity int    `json:"quantity"`
+	Unit     string `json:"unit"`
+	Side     string `json:"side,omitempty"`
+	Name     string `json:"name"`
+}
+
+// Units an exercise quantity can be counted in.
+const (
+	unitReps    = "reps"
+	unitSeconds = "seconds"
+	unitMinutes = "minutes"
+)
+
+// label formats the quantity for display, e.g. "10 reps per leg".
+func (e workoutExercise) label() string {
+	label := strconv.Itoa(e.Quantity) + " " + e.Unit
+	if e.Side != "" {
+		label += " " + e.Side
+	}
+	return label
+}
+
+var (
+	dayRegexp      = regexp.MustCompile(`^day\s+\d+`)
+	levelRegexp    = regexp.MustCompile(`^level\s*([ivxl1]+)\s+(\d+)\s*sets?$`)
+	restRegexp     = regexp.MustCompile(`(\d+)\s*(seconds?|secs?|minutes?|mins?)\s+rest`)
+	exerciseRegexp = regexp.MustCompile(`(?i)^(\d+)(?:\s*(x|s|secs?|mins?)\s+|\s+)(.+)`)
+	unitRegexp     = regexp.MustCompile(`(?i)^(reps?|x|seconds?|secs?|s|minutes?|mins?)(?:\s+(.*)|$)`)
+	sideRegexp     = regexp.MustCompile(`(?i)\(?\b(per|each)\s+(leg|side|arm)\b\)?`)
+	compoundRegexp = regexp.MustCompile(`(?i)\s*\+\s*|\s+(?:and|&)\s+`)
+)
+
+// parseExerciseLine splits a line like "20 knee strikes" or "30s plank" into
+// its quantity, unit and exercise name. ok is false for lines that don't start
+// with a count, which are never exercises. Only a unit can be written against
+// the count, as in "30s" or "10x", so bare numbers like "2018" aren't read as
+// exercises.
+func parseExerciseLine(line string) (parsed workoutExercise, ok bool) {
+	matches := exerciseRegexp.FindStringSubmatch(strings.TrimSpace(line))
+	if matches == nil {
+		return parsed, false
+	}
+	parsed.Quantity, _ = strconv.Atoi(matches[1])
+	unit, name := matches[2], matches[3]
+	if unit == "" {
+		if unitMatches := unitRegexp.FindStringSubmatch(name); unitMatches != nil {
+			unit, name = unitMatches[1], unitMatches[2]
+		}
+	}
+	parsed.Unit = normaliseUnit(unit)
+	if side := sideRegexp.FindStringSubmatch(name); side != nil {
+		parsed.Side = strings.ToLower(side[1] + " " + side[2])
+		name = sideRegexp.ReplaceAllString(name, " ")
+	}
+	parsed.Name = strings.Join(strings.Fields(name), " ")
+	return parsed, parsed.Name != ""
+}
+
+// mergeWrappedLines joins lines without a leading count onto the exercise line
+// before them, as when "20 side-to-side chops" wraps onto a second line, but
+// only when the joined name matches a known exercise better than the first
+// line does on its own.
+func mergeWrappedLines(lines []ocrLine, catalog *exerciseCatalog, threshold float64) []ocrLine {
+	var merged []ocrLine
+	for _, line := range lines {
+		if n := len(merged); n > 0 {
+			if _, ok := parseExerciseLine(line.Text); !ok {
+				previous := merged[n-1]
+				joined := previous.Text + " " + strings.TrimSpace(line.Text)
+				previousScore := catalogScore(catalog, previous.Text)
+				joinedScore := catalogScore(catalog, joined)
+				if previousScore > 0 && joinedScore >= threshold && joinedScore > previousScore {
+					confidence := previous.Confidence
+					if line.Confidence < confidence {
+						confidence = line.Confidence
+					}
+					merged[n-1] = ocrLine{Text: joined, Box: previous.Box.union(line.Box), Confidence: confidence}
+					continue
+				}
+			}
+		}
+		merged = append(merged, line)
+	}
+	return merged
+}
+
+// catalogScore is how closely an exercise line matches a known exercise, or 0
+// if it isn't an exercise line.
+func catalogScore(catalog *exerciseCatalog, line string) float64 {
+	videoName := getVideoName(line)
+	if videoName == "" {
+		return 0
+	}
+	match, _ := catalog.match(videoName, 1)
+	return match.Score
+}
+
+// splitCompoundLine splits a line joining several exercises, like
+// "10 push-ups + 10 squats", into one line per exercise. Lines that match a
+// known exercise as a whole, like the "20 jab + jab + cross" combo (even when
+// misread), are kept intact. A part without its own count, as in "10 push-ups and squats", takes
+// the count of the first part, but only if every part is a known exercise.
+func splitCompoundLine(line string, catalog *exerciseCatalog, threshold float64) []string {
+	parts := compoundRegexp.Split(strings.TrimSpace(line), -1)
+	if len(parts) < 2 {
+		return []string{line}
+	}
+	if _, ok := catalog.match(getVideoName(line), threshold); ok {
+		return []string{line}
+	}
+	first, ok := parseExerciseLine(parts[0])
+	if !ok {
+		return []string{line}
+	}
+	split := []string{parts[0]}
+	for _, part := range parts[1:] {
+		if _, ok := parseExerciseLine(part); !ok {
+			part = first.label() + " " + part
+			if _, ok := catalog.match(getVideoName(part), threshold); !ok {
+				return []string{line}
+			}
+		}
+		split = append(split, part)
+	}
+	return split
+}
+
+func normaliseUnit(unit string) string {
+	unit = strings.ToLower(unit)
+	switch {
+	case strings.HasPrefix(unit, "s"):
+		return unitSeconds
+	case strings.HasPrefix(unit, "m"):
+		return unitMinutes
+	}
+	return unitReps
+}
+
+// Kinds of line on a workout graphic.
+const (
+	lineIgnored  = "ignored"
+	lineDay      = "day"
+	lineLevel    = "level"
+	lineRest     = "rest"
+	lineExercise = "exercise"
+	lineText     = "text"
+)
+
+// classifyLine says what a line of a workout graphic is. Text lines are the
+// title or notes, depending on where they are.
+func classifyLine(line string) string {
+	line = strings.Join(strings.Fields(line), " ")
+	lower := strings.ToLower(line)
+	switch {
+	case line == "" || strings.Contains(lower, "darebee.com"):
+		return lineIgnored
+	case dayRegexp.MatchString(lower):
+		return lineDay
+	case levelRegexp.MatchString(lower):
+		return lineLevel
+	case restRegexp.MatchString(lower):
+		return lineRest
+	case getVideoName(line) != "":
+		return lineExercise
+	}
+	return lineText
+}
+
+// parseWorkout builds the workout structure from the OCR lines of a workout
+// graphic, in reading order.
+func parseWorkout(lines []string) *Workout {
+	workout := &Workout{}
+	var header []string
+	for _, line := range lines {
+		line = strings.Join(strings.Fields(line), " ")
+		lower := strings.ToLower(line)
+		switch kind := classifyLine(line); {
+		case kind == lineIgnored:
+			continue
+		case kind == lineDay:
+			workout.Day = line
+		case kind == lineLevel:
+			matches := levelRegexp.FindStringSubmatch(lower)
+			sets, _ := strconv.Atoi(matches[2])
+			workout.Levels = append(workout.Levels, workoutLevel{Name: "Level " + romanLevel(matches[1]), Sets: sets})
+		case kind == lineRest:
+			matches := restRegexp.FindStringSubmatch(lower)
+			workout.Rest = append(workout.Rest, restInterval{Seconds: restSeconds(matches[1], matches[2]), Description: line})
+		case kind == lineExercise:
+			parsed, _ := parseExerciseLine(line)
+			workout.Exercises = append(workout.Exercises, parsed)
+		case len(workout.Levels) == 0 && len(workout.Exercises) == 0:
+			header = append(header, line)
+		default:
+			workout.Notes = append(workout.Notes, line)
+		}
+	}
+	workout.Title = strings.Join(header, " ")
+	return workout
+}
+
+// romanLevel normalises a level numeral, where OCR often reads "I" as "l" or
+// "1" (so "Level I" comes out as "Levell").
+func romanLevel(numeral string) string {
+	return strings.ToUpper(strings.NewReplacer("l", "i", "1", "i").Replace(numeral))
+}
+
+func restSeconds(amount string, unit string) int {
+	n, _ := strconv.Atoi(amount)
+	if strings.HasPrefix(unit, "min") {
+		return n * 60
+	}
+	return n
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"gotest.tools/assert"
+)
+
+func TestParseWorkout(t *testing.T) {
+	t.Run("program day", func(t *testing.T) {
+		workout := parseWorkout([]string{
+			"Foundation",
+			"Day 3 Fighter",
+			"Levell 3 sets",
+			"Level II 5 sets",
+			"Level III 7 sets",
+			"2 minutes rest between sets",
+			"20 knee strikes",
+			"20 low front kicks",
+			"20 overhead punches",
+			"20 jab + jab + cross",
+			"o darebee.com",
+		})
+		assert.DeepEqual(t, &Workout{
+			Title: "Foundation",
+			Day:   "Day 3 Fighter",
+			Levels: []workoutLevel{
+				{Name: "Level I", Sets: 3},
+				{Name: "Level II", Sets: 5},
+				{Name: "Level III", Sets: 7},
+			},
+			Rest: []restInterval{{Seconds: 120, Description: "2 minutes rest between sets"}},
+			Exercises: []workoutExercise{
+				{Quantity: 20, Unit: "reps", Name: "knee strikes"},
+				{Quantity: 20, Unit: "reps", Name: "low front kicks"},
+				{Quantity: 20, Unit: "reps", Name: "overhead punches"},
+				{Quantity: 20, Unit: "reps", Name: "jab + jab + cross"},
+			},
+		}, workout)
+	})
+	t.Run("rest in seconds", func(t *testing.T) {
+		workout := parseWorkout([]string{"30 seconds rest between exercises"})
+		assert.DeepEqual(t, []restInterval{{Seconds: 30, Description: "30 seconds rest between exercises"}}, workout.Rest)
+	})
+	t.Run("unrecognised lines after the header are kept as notes", func(t *testing.T) {
+		workout := parseWorkout([]string{"Power Punch", "10 burpees", "repeat  3 times"})
+		assert.Equal(t, "Power Punch", workout.Title)
+		assert.DeepEqual(t, []string{"repeat 3 times"}, workout.Notes)
+	})
+}
+
+func TestParseExerciseLine(t *testing.T) {
+	t.Run("reps", func(t *testing.T) {
+		parsed, ok := parseExerciseLine("20 knee strikes")
+		assert.Assert(t, ok)
+		assert.DeepEqual(t, workoutExercise{Quantity: 20, Unit: "reps", Name: "knee strikes"}, parsed)
+		parsed, _ = parseExerciseLine("10 reps squats")
+		assert.DeepEqual(t, workoutExercise{Quantity: 10, Unit: "reps", Name: "squats"}, parsed)
+		parsed, _ = parseExerciseLine("20x sit-ups")
+		assert.DeepEqual(t, workoutExercise{Quantity: 20, Unit: "reps", Name: "sit-ups"}, parsed)
+	})
+	t.Run("time", func(t *testing.T) {
+		parsed, _ := parseExerciseLine("30s plank")
+		assert.DeepEqual(t, workoutExercise{Quantity: 30, Unit: "seconds", Name: "plank"}, parsed)
+		parsed, _ = parseExerciseLine("20 seconds jumping jacks")
+		assert.DeepEqual(t, workoutExercise{Quantity: 20, Unit: "seconds", Name: "jumping jacks"}, parsed)
+		parsed, _ = parseExerciseLine("1 min wall sit")
+		assert.DeepEqual(t, workoutExercise{Quantity: 1, Unit: "minutes", Name: "wall sit"}, parsed)
+	})
+	t.Run("names starting like units", func(t *testing.T) {
+		parsed, _ := parseExerciseLine("20 squats")
+		assert.Equal(t, "squats", parsed.Name)
+		parsed, _ = parseExerciseLine("20 x-jacks")
+		assert.DeepEqual(t, workoutExercise{Quantity: 20, Unit: "reps", Name: "x-jacks"}, parsed)
+		assert.Equal(t, "x-jacks", getVideoName("20 x-jacks"))
+		parsed, _ = parseExerciseLine("20 mountain climbers")
+		assert.Equal(t, "mountain climbers", parsed.Name)
+	})
+	t.Run("sides", func(t *testing.T) {
+		parsed, _ := parseExerciseLine("10 lunges per leg")
+		assert.DeepEqual(t, workoutExercise{Quantity: 10, Unit: "reps", Side: "per leg", Name: "lunges"}, parsed)
+		parsed, _ = parseExerciseLine("30 sec side plank (each side)")
+		assert.DeepEqual(t, workoutExercise{Quantity: 30, Unit: "seconds", Side: "each side", Name: "side plank"}, parsed)
+	})
+	t.Run("not exercises", func(t *testing.T) {
+		_, ok := parseExerciseLine("Foundation")
+		assert.Assert(t, !ok)
+		_, ok = parseExerciseLine("10 reps")
+		assert.Assert(t, !ok)
+	})
+	t.Run("bare numbers", func(t *testing.T) {
+		for _, line := range []string{"12", "100", "2018"} {
+			_, ok := parseExerciseLine(line)
+			assert.Assert(t, !ok, line)
+			assert.Equal(t, "", getVideoName(line))
+		}
+	})
+	t.Run("units written against the count", func(t *testing.T) {
+		parsed, ok := parseExerciseLine("30s plank")
+		assert.Assert(t, ok)
+		assert.DeepEqual(t, workoutExercise{Quantity: 30, Unit: "seconds", Name: "plank"}, parsed)
+		parsed, ok = parseExerciseLine("10x burpees")
+		assert.Assert(t, ok)
+		assert.DeepEqual(t, workoutExercise{Quantity: 10, Unit: "reps", Name: "burpees"}, parsed)
+	})
+}
+
+func TestClassifyLine(t *testing.T) {
+	assert.Equal(t, lineExercise, classifyLine("20 squats"))
+	assert.Equal(t, lineRest, classifyLine("1 minute rest"))
+	assert.Equal(t, lineRest, classifyLine("30 seconds rest"))
+	assert.Equal(t, lineLevel, classifyLine("Level II 5 sets"))
+	assert.Equal(t, lineDay, classifyLine("Day 3 Fighter"))
+	assert.Equal(t, lineIgnored, classifyLine("darebee.com"))
+	assert.Equal(t, lineText, classifyLine("Foundation"))
+}
+
+func TestSplitCompoundLine(t *testing.T) {
+	catalog := newExerciseCatalog(seedCatalogEntries())
+	t.Run("separate counts", func(t *testing.T) {
+		assert.DeepEqual(t, []string{"10 push-ups", "10 squats"}, splitCompoundLine("10 push-ups + 10 squats", catalog, 0.8))
+		assert.DeepEqual(t, []string{"20 climbers", "10 sit-ups"}, splitCompoundLine("20 climbers and 10 sit-ups", catalog, 0.8))
+	})
+	t.Run("shared count", func(t *testing.T) {
+		assert.DeepEqual(t, []string{"10 push-ups", "10 reps squats"}, splitCompoundLine("10 push-ups and squats", catalog, 0.8))
+		assert.DeepEqual(t, []string{"10 lunges per leg", "10 reps per leg calf raises"}, splitCompoundLine("10 lunges per leg + calf raises", catalog, 0.8))
+	})
+	t.Run("known combo", func(t *testing.T) {
+		assert.DeepEqual(t, []string{"20 jab + jab + cross"}, splitCompoundLine("20 jab + jab + cross", catalog, 0.8))
+	})
+	t.Run("misread combo", func(t *testing.T) {
+		assert.DeepEqual(t, []string{"20 jab + jab + crass"}, splitCompoundLine("20 jab + jab + crass", catalog, 0.8))
+	})
+	t.Run("unknown parts without counts", func(t *testing.T) {
+		assert.DeepEqual(t, []string{"20 punch + block"}, splitCompoundLine("20 punch + block", catalog, 0.8))
+	})
+	t.Run("not compound", func(t *testing.T) {
+		assert.DeepEqual(t, []string{"20 knee strikes"}, splitCompoundLine("20 knee strikes", catalog, 0.8))
+		assert.DeepEqual(t, []string{"2 minutes rest between sets"}, splitCompoundLine("2 minutes rest between sets", catalog, 0.8))
+	})
+}
+
+func TestMergeWrappedLines(t *testing.T) {
+	catalog := newExerciseCatalog(seedCatalogEntries())
+	// The day05 fixture is hand-written rather than recorded: text laid out
+	// like day03, with names broken where a narrow graphic would wrap them.
+	t.Run("synthetic wrapped lines", func(t *testing.T) {
+		detector, err := newFixtureDetector("testdata/ocr")
+		assert.NilError(t, err)
+		annotation, err := detector.DetectText(context.Background(), &workoutImage{URL: "https://darebee.com/images/programs/foundation/web/day05.jpg"})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, []string{
+			"Foundation",
+			"Day 5 Fighter",
+			"Levell 3 sets",
+			"Level II 5 sets",
+			"Level III 7 sets",
+			"2 minutes rest between sets",
+			"20 side-to-side chops",
+			"10 push-ups",
+			"20 alt arm-leg raises",
+			"20 low front kicks",
+			"o darebee.com",
+		}, lineTexts(mergeWrappedLines(getLinesForAnnotation(annotation), catalog, 0.8)))
+	})
+	t.Run("keeps the lowest confidence", func(t *testing.T) {
+		merged := mergeWrappedLines([]ocrLine{
+			{Text: "20 side-to-side", Box: box{0, 0, 100, 10}, Confidence: 0.9},
+			{Text: "chops", Box: box{0, 12, 40, 22}, Confidence: 0.7},
+		}, catalog, 0.8)
+		assert.DeepEqual(t, []ocrLine{{Text: "20 side-to-side chops", Box: box{0, 0, 100, 22}, Confidence: 0.7}}, merged)
+	})
+	t.Run("notes after a complete name", func(t *testing.T) {
+		lines := []ocrLine{{Text: "20 knee strikes"}, {Text: "Extra credit"}}
+		assert.DeepEqual(t, lines, mergeWrappedLines(lines, catalog, 0.8))
+	})
+}